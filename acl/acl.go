@@ -0,0 +1,173 @@
+// Package acl decides which operations a principal may perform on which
+// keys. Policies are read from a YAML file and can be reloaded while the
+// server runs.
+package acl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OpGet    = "get"
+	OpPut    = "put"
+	OpDelete = "delete"
+	OpScan   = "scan"
+	OpAdmin  = "admin"
+)
+
+var ops = []string{OpGet, OpPut, OpDelete, OpScan, OpAdmin}
+
+// Anonymous is the principal name used for unauthenticated requests.
+const Anonymous = "anonymous"
+
+// Rule allows Principal ("*" for anyone) to perform Operations on keys
+// matching any of the Keys glob patterns (path.Match syntax, e.g. "1*").
+type Rule struct {
+	Principal  string   `yaml:"principal"`
+	Operations []string `yaml:"operations"`
+	Keys       []string `yaml:"keys"`
+}
+
+// Policy is an allow list: anything no rule allows is denied.
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Parse decodes and validates a YAML policy.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	for i, r := range p.Rules {
+		if r.Principal == "" {
+			return nil, fmt.Errorf("rule %d: principal must be set", i)
+		}
+		for _, op := range r.Operations {
+			if op != "*" && !slices.Contains(ops, op) {
+				return nil, fmt.Errorf("rule %d: unknown operation %q", i, op)
+			}
+		}
+		for _, pattern := range r.Keys {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: key pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Allowed reports whether principal may perform op on key.
+func (p *Policy) Allowed(principal, op, key string) bool {
+	for _, r := range p.Rules {
+		if r.Principal != "*" && r.Principal != principal {
+			continue
+		}
+		if !slices.Contains(r.Operations, op) && !slices.Contains(r.Operations, "*") {
+			continue
+		}
+		for _, pattern := range r.Keys {
+			if ok, _ := path.Match(pattern, key); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ACL holds the policy loaded from a file. A nil *ACL allows everything.
+type ACL struct {
+	path    string
+	policy  atomic.Pointer[Policy]
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// Load reads the policy at path.
+func Load(path string) (*ACL, error) {
+	a := &ACL{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload re-reads the policy file. On error the previous policy stays in
+// force.
+func (a *ACL) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("acl %s: %w", a.path, err)
+	}
+
+	a.policy.Store(p)
+	a.modTime = fi.ModTime()
+	return nil
+}
+
+// Watch reloads the policy whenever the file's modification time changes,
+// checking every interval until ctx is cancelled.
+func (a *ACL) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(a.path)
+		if err != nil {
+			slog.Error("Failed to stat ACL file", "path", a.path, "err", err)
+			continue
+		}
+		a.mu.Lock()
+		changed := !fi.ModTime().Equal(a.modTime)
+		a.mu.Unlock()
+		if !changed {
+			continue
+		}
+
+		if err := a.Reload(); err != nil {
+			slog.Error("Failed to reload ACL file, keeping previous policy", "path", a.path, "err", err)
+			continue
+		}
+		slog.Info("Reloaded ACL file", "path", a.path)
+	}
+}
+
+// Allowed reports whether principal may perform op on key under the
+// current policy.
+func (a *ACL) Allowed(principal, op, key string) bool {
+	if a == nil {
+		return true
+	}
+	return a.policy.Load().Allowed(principal, op, key)
+}
@@ -0,0 +1,54 @@
+package acl
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExamplePolicyIsolatesTeams(t *testing.T) {
+	data, err := os.ReadFile("../acl.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		principal, op, key string
+		want               bool
+	}{
+		{"team-a", OpGet, "12", true},
+		{"team-a", OpPut, "12", true},
+		{"team-a", OpGet, "21", false},
+		{"team-a", OpScan, "21", false},
+		{"team-a", OpPut, "21", false},
+		{"team-b", OpGet, "12", false},
+		{"team-b", OpDelete, "21", true},
+		// Shared keys are readable by everyone and writable by no one.
+		{"team-a", OpGet, "90", true},
+		{"team-b", OpScan, "90", true},
+		{"team-a", OpPut, "90", false},
+		{Anonymous, OpGet, "12", false},
+		{Anonymous, OpAdmin, "admin.cache.clear", true},
+		{"team-a", OpAdmin, "admin.cache.clear", false},
+	} {
+		if got := p.Allowed(tc.principal, tc.op, tc.key); got != tc.want {
+			t.Errorf("Allowed(%s, %s, %s) = %v, want %v", tc.principal, tc.op, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestParseRejectsBadRules(t *testing.T) {
+	for _, policy := range []string{
+		"rules:\n  - operations: [get]\n    keys: ['*']\n",
+		"rules:\n  - principal: a\n    operations: [read]\n    keys: ['*']\n",
+		"rules:\n  - principal: a\n    operations: [get]\n    keys: ['[']\n",
+		"rules:\n  - principal: a\n    operation: [get]\n",
+	} {
+		if _, err := Parse([]byte(policy)); err == nil {
+			t.Errorf("Parse accepted %q", policy)
+		}
+	}
+}
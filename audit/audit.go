@@ -0,0 +1,265 @@
+// Package audit appends a JSON line for every mutation to a local file,
+// rotating it by size, and answers queries over the retained files.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+// Record describes one mutation. OldVersion is 0 when a put created the
+// key; NewVersion is 0 for a delete.
+type Record struct {
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"`
+	Operation  string    `json:"operation"`
+	Key        int       `json:"key"`
+	OldVersion uint64    `json:"old_version"`
+	NewVersion uint64    `json:"new_version"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Log writes records to path. When the file would grow past maxSize bytes
+// it is renamed to path.1, shifting older files up to path.<maxBackups>;
+// the oldest is removed. Files are only ever appended to.
+type Log struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func Open(path string, maxSize int64, maxBackups int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	var err error
+	if l.f, l.size, err = openFile(path); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func openFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// Write appends r as one line. The write goes straight to the file, so a
+// record that was written survives a crash of the server.
+//
+// If rotation fails the record is still appended to the file in use, and
+// rotation is tried again on the next write; the error is returned so it
+// can be reported.
+func (l *Log) Write(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rotateErr error
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			rotateErr = fmt.Errorf("rotating %s: %w", l.path, err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return errors.Join(err, rotateErr)
+}
+
+// rotate shifts the files up and starts a new one. The old file is kept
+// open until the new one is, so a failure leaves the log appending to the
+// file it has rather than a closed one.
+func (l *Log) rotate() error {
+	// An earlier rotation may have moved the current file aside and then
+	// failed to open the next one, leaving only the open to retry.
+	if _, err := os.Stat(l.path); err == nil {
+		if err := l.shift(); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, size, err := openFile(l.path)
+	if err != nil {
+		return err
+	}
+	l.f.Close()
+	l.f, l.size = f, size
+	return nil
+}
+
+// shift moves each file up one place, dropping the oldest.
+func (l *Log) shift() error {
+	os.Remove(l.backup(l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if l.maxBackups > 0 {
+		return os.Rename(l.path, l.backup(1))
+	}
+	return os.Remove(l.path)
+}
+
+func (l *Log) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Filter selects records. Zero fields match everything.
+type Filter struct {
+	Key   *int
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+func (f Filter) match(r Record) bool {
+	if f.Key != nil && r.Key != *f.Key {
+		return false
+	}
+	if !f.From.IsZero() && r.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// Query returns the records matching f, oldest first, reading the rotated
+// files before the current one. With a Limit only the newest Limit
+// matches are returned.
+//
+// The files are opened under the lock, so rotation cannot move them in
+// between, but read without it so writes carry on during a long query.
+func (l *Log) Query(f Filter) ([]Record, error) {
+	files, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(files)
+
+	out := newest{n: f.Limit}
+	for _, sf := range files {
+		if err := scan(io.LimitReader(sf.file, sf.size), f, &out); err != nil {
+			return nil, fmt.Errorf("%s: %w", sf.name, err)
+		}
+	}
+	return out.records(), nil
+}
+
+// snapshotFile is a retained file opened for a query. size is its length
+// when it was opened, so lines appended later are left out.
+type snapshotFile struct {
+	name string
+	file *os.File
+	size int64
+}
+
+// snapshot opens the retained files, oldest first.
+func (l *Log) snapshot() ([]snapshotFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var files []snapshotFile
+	for i := l.maxBackups; i >= 0; i-- {
+		name := l.path
+		if i > 0 {
+			name = l.backup(i)
+		}
+		file, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			closeAll(files)
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			closeAll(files)
+			return nil, err
+		}
+		files = append(files, snapshotFile{name: name, file: file, size: info.Size()})
+	}
+	return files, nil
+}
+
+func closeAll(files []snapshotFile) {
+	for _, sf := range files {
+		sf.file.Close()
+	}
+}
+
+// newest keeps the last n records added, or every record if n is 0.
+type newest struct {
+	n    int
+	buf  []Record
+	next int
+}
+
+func (b *newest) add(r Record) {
+	if b.n == 0 || len(b.buf) < b.n {
+		b.buf = append(b.buf, r)
+		return
+	}
+	b.buf[b.next] = r
+	b.next = (b.next + 1) % b.n
+}
+
+// records returns the kept records, oldest first.
+func (b *newest) records() []Record {
+	out := make([]Record, 0, len(b.buf))
+	out = append(out, b.buf[b.next:]...)
+	return append(out, b.buf[:b.next]...)
+}
+
+func scan(r io.Reader, f Filter, out *newest) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// A torn final line from a crash mid-write; skip it.
+			continue
+		}
+		if f.match(rec) {
+			out.add(rec)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// record returns a put of key at a time that orders records by key.
+func record(key int) Record {
+	return Record{
+		Time:       time.Date(2026, 1, 1, 0, 0, key, 0, time.UTC),
+		Principal:  "svc",
+		Operation:  OpPut,
+		Key:        key,
+		NewVersion: 1,
+	}
+}
+
+func keys(records []Record) []int {
+	out := make([]int, len(records))
+	for i, r := range records {
+		out[i] = r.Key
+	}
+	return out
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fileKeys returns the keys of the records in one file.
+func fileKeys(t *testing.T, name string) []int {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out newest
+	if err := scan(f, Filter{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	return keys(out.records())
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// Every record is the same length, so a file holds exactly two.
+	l, err := Open(path, 250, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for key := 10; key < 17; key++ {
+		if err := l.Write(record(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		want []int
+	}{
+		{path + ".2", []int{12, 13}},
+		{path + ".1", []int{14, 15}},
+		{path, []int{16}},
+	} {
+		if got := fileKeys(t, tc.name); !equal(got, tc.want) {
+			t.Errorf("%s: got keys %v, want %v", filepath.Base(tc.name), got, tc.want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than max_backups files kept: %v", err)
+	}
+}
+
+func TestQueryAcrossRotatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 250, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for key := 10; key < 17; key++ {
+		if err := l.Write(record(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := 13
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"all", Filter{}, []int{10, 11, 12, 13, 14, 15, 16}},
+		// The newest matches, still oldest first, across file boundaries.
+		{"limit", Filter{Limit: 3}, []int{14, 15, 16}},
+		{"limit over matches", Filter{Limit: 20}, []int{10, 11, 12, 13, 14, 15, 16}},
+		{"key", Filter{Key: &key}, []int{13}},
+		{"range", Filter{From: record(11).Time, To: record(15).Time}, []int{11, 12, 13, 14}},
+		{"range and limit", Filter{From: record(11).Time, To: record(15).Time, Limit: 2}, []int{13, 14}},
+	} {
+		records, err := l.Query(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keys(records); !equal(got, tc.want) {
+			t.Errorf("%s: got keys %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWriteSurvivesFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 250, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for key := 10; key < 12; key++ {
+		if err := l.Write(record(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A directory in the way of the backup makes the rotation fail.
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(record(12)); err == nil {
+		t.Fatal("rotation into a directory succeeded")
+	}
+	// The record still went to the current file, which stays usable.
+	if got := fileKeys(t, path); !equal(got, []int{10, 11, 12}) {
+		t.Fatalf("after failed rotation: got keys %v", got)
+	}
+
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(record(13)); err != nil {
+		t.Fatalf("rotation retried: %v", err)
+	}
+	if records, err := l.Query(Filter{}); err != nil || !equal(keys(records), []int{10, 11, 12, 13}) {
+		t.Fatalf("after rotation: got %v, %v", keys(records), err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("no backup after the retried rotation: %v", err)
+	}
+}
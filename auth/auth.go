@@ -0,0 +1,170 @@
+// Package auth authenticates clients by static API key or HMAC-signed JWT
+// bearer token.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"decsproject/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var (
+	ErrNoCredentials      = errors.New("auth: no credentials")
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+)
+
+// Principal is an authenticated client.
+type Principal struct {
+	Name string
+	// Method is "api_key" or "jwt".
+	Method string
+	Scopes []string
+}
+
+// HasScope reports whether p may perform operations needing scope. A
+// principal without scopes has every scope.
+func (p *Principal) HasScope(scope string) bool {
+	return len(p.Scopes) == 0 || slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored by WithPrincipal, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+type Authenticator struct {
+	// keys is indexed by the SHA-256 of the key so lookups do not compare
+	// secrets byte by byte.
+	keys     map[[32]byte]*Principal
+	secret   []byte
+	issuer   string
+	audience string
+}
+
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:     make(map[[32]byte]*Principal),
+		secret:   []byte(cfg.JWT.Secret),
+		issuer:   cfg.JWT.Issuer,
+		audience: cfg.JWT.Audience,
+	}
+
+	if cfg.JWT.SecretFile != "" {
+		b, err := os.ReadFile(cfg.JWT.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		a.secret = []byte(strings.TrimSpace(string(b)))
+	}
+
+	for _, k := range cfg.APIKeys {
+		a.keys[sha256.Sum256([]byte(k.Key))] = &Principal{Name: k.Name, Method: "api_key", Scopes: k.Scopes}
+	}
+	return a, nil
+}
+
+// Authenticate identifies the client from an X-API-Key header or an
+// Authorization: Bearer token.
+func (a *Authenticator) Authenticate(req *http.Request) (*Principal, error) {
+	if key := req.Header.Get(APIKeyHeader); key != "" {
+		return a.AuthenticateKey(key)
+	}
+
+	authz := req.Header.Get("Authorization")
+	if authz == "" {
+		return nil, ErrNoCredentials
+	}
+	scheme, token, found := strings.Cut(authz, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrInvalidCredentials
+	}
+	return a.AuthenticateToken(strings.TrimSpace(token))
+}
+
+// AuthenticateKey identifies the client holding an API key, for protocols
+// without HTTP headers.
+func (a *Authenticator) AuthenticateKey(key string) (*Principal, error) {
+	if p, found := a.keys[sha256.Sum256([]byte(key))]; found {
+		return p, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// AuthenticateToken identifies the client from a bearer token.
+func (a *Authenticator) AuthenticateToken(token string) (*Principal, error) {
+	if len(a.secret) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	return a.parseToken(token)
+}
+
+// claims are the JWT claims the server understands: the registered claims
+// plus a space-separated OAuth-style scope.
+type claims struct {
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (a *Authenticator) parseToken(token string) (*Principal, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"})}
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, func(*jwt.Token) (any, error) {
+		return a.secret, nil
+	}, opts...)
+	if err != nil || c.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: c.Subject, Method: "jwt", Scopes: strings.Fields(c.Scope)}, nil
+}
+
+// NewToken signs an HS256 token for subject. A zero ttl produces a token
+// without an expiry.
+func NewToken(secret []byte, subject string, scopes []string, ttl time.Duration, issuer, audience string) (string, error) {
+	now := time.Now()
+	c := claims{
+		Scope: strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  subject,
+			Issuer:   issuer,
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+	}
+	if audience != "" {
+		c.Audience = jwt.ClaimStrings{audience}
+	}
+	if ttl > 0 {
+		c.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(secret)
+}
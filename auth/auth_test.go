@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"decsproject/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var secret = []byte("test-secret")
+
+func newAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	a, err := New(config.AuthConfig{
+		Enabled: true,
+		APIKeys: []config.APIKey{{Name: "team-a", Key: "key-a", Scopes: []string{ScopeRead}}},
+		JWT:     config.JWTConfig{Secret: string(secret), Issuer: "kv-test", Audience: "kv"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, c jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, c).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	a := newAuthenticator(t)
+
+	token, err := NewToken(secret, "svc", []string{ScopeRead, ScopeWrite}, time.Hour, "kv-test", "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := a.AuthenticateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "svc" || p.Method != "jwt" || !slices.Equal(p.Scopes, []string{ScopeRead, ScopeWrite}) {
+		t.Fatalf("got %+v", p)
+	}
+	if p.HasScope(ScopeAdmin) {
+		t.Error("token holds admin without the scope")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func() claims {
+		return claims{RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "svc",
+			Issuer:    "kv-test",
+			Audience:  jwt.ClaimStrings{"kv"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}}
+	}
+	expired := valid()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	notYet := valid()
+	notYet.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	wrongIssuer := valid()
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := valid()
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+	noSubject := valid()
+	noSubject.Subject = ""
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(t, jwt.SigningMethodHS256, secret, expired)},
+		{"not yet valid", sign(t, jwt.SigningMethodHS256, secret, notYet)},
+		{"bad signature", sign(t, jwt.SigningMethodHS256, []byte("other-secret"), valid())},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid())},
+		{"alg ES256", sign(t, jwt.SigningMethodES256, ecKey, valid())},
+		{"wrong issuer", sign(t, jwt.SigningMethodHS256, secret, wrongIssuer)},
+		{"wrong audience", sign(t, jwt.SigningMethodHS256, secret, wrongAudience)},
+		{"no subject", sign(t, jwt.SigningMethodHS256, secret, noSubject)},
+		{"garbage", "not.a.token"},
+	} {
+		if p, err := a.AuthenticateToken(tc.token); err != ErrInvalidCredentials {
+			t.Errorf("%s: got %+v, %v; want ErrInvalidCredentials", tc.name, p, err)
+		}
+	}
+
+	// HS384 and HS512 share the secret and are accepted.
+	if _, err := a.AuthenticateToken(sign(t, jwt.SigningMethodHS512, secret, valid())); err != nil {
+		t.Errorf("HS512: %v", err)
+	}
+
+	// Without a secret no token is accepted, not even one signed with an
+	// empty key.
+	noJWT, err := New(config.AuthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noJWT.AuthenticateToken(sign(t, jwt.SigningMethodHS256, []byte{}, valid())); err != ErrInvalidCredentials {
+		t.Errorf("no secret configured: got %v", err)
+	}
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	a := newAuthenticator(t)
+	token, err := NewToken(secret, "svc", nil, 0, "kv-test", "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, header, value string
+		wantName            string
+		wantErr             error
+	}{
+		{"api key", APIKeyHeader, "key-a", "team-a", nil},
+		{"bad api key", APIKeyHeader, "key-b", "", ErrInvalidCredentials},
+		{"bearer", "Authorization", "Bearer " + token, "svc", nil},
+		{"lower-case scheme", "Authorization", "bearer " + token, "svc", nil},
+		{"basic", "Authorization", "Basic dXNlcjpwYXNz", "", ErrInvalidCredentials},
+		{"none", "", "", "", ErrNoCredentials},
+	} {
+		req := httptest.NewRequest("GET", "/get", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		p, err := a.Authenticate(req)
+		if err != tc.wantErr || (p != nil && p.Name != tc.wantName) {
+			t.Errorf("%s: got %+v, %v; want %q, %v", tc.name, p, err, tc.wantName, tc.wantErr)
+		}
+	}
+
+	// A principal without scopes holds all of them.
+	p := &Principal{Name: "svc"}
+	if !p.HasScope(ScopeRead) || !p.HasScope(ScopeAdmin) {
+		t.Error("unscoped principal lacks a scope")
+	}
+}
@@ -0,0 +1,358 @@
+// Package binproto implements the server's binary TCP protocol: compact
+// length-prefixed frames carrying a request ID, so a client can pipeline
+// many requests on one connection and the server can answer them in
+// whatever order they complete.
+//
+// Every frame is
+//
+//	length  uint32  bytes that follow this field
+//	id      uint32  chosen by the client, echoed in the response
+//	code    uint8   an Op in requests, a Status in responses
+//	body    length-5 bytes
+//
+// with integers in big-endian order. Request bodies are
+//
+//	OpGet, OpDelete  key int64
+//	OpPut            key int64, value (the rest of the body)
+//	OpBatch          count uint16, then count entries of
+//	                 op uint8, key int64, value length uint32, value
+//	OpAuth           kind uint8 (AuthAPIKey or AuthToken), credential
+//	OpPing           empty
+//
+// and a response body with StatusOK is the value for OpGet, the version
+// written or deleted as a uint64 for OpPut and OpDelete, the principal
+// name for OpAuth and empty for OpPing. OpBatch is answered with count
+// uint16 and then, for each entry in order, status uint8, body length
+// uint32 and body. Any other status carries an error message.
+package binproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Op identifies a request.
+type Op uint8
+
+const (
+	OpGet Op = iota + 1
+	OpPut
+	OpDelete
+	OpBatch
+	OpAuth
+	OpPing
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	case OpBatch:
+		return "batch"
+	case OpAuth:
+		return "auth"
+	case OpPing:
+		return "ping"
+	}
+	return "op(" + strconv.Itoa(int(op)) + ")"
+}
+
+// Status is the outcome of a request.
+type Status uint8
+
+const (
+	StatusOK Status = iota
+	StatusNotFound
+	StatusBadRequest
+	StatusUnauthorized
+	StatusForbidden
+	StatusTooLarge
+	StatusRateLimited
+	StatusUnavailable
+	StatusTimeout
+	StatusError
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusNotFound:
+		return "not found"
+	case StatusBadRequest:
+		return "bad request"
+	case StatusUnauthorized:
+		return "unauthorized"
+	case StatusForbidden:
+		return "forbidden"
+	case StatusTooLarge:
+		return "too large"
+	case StatusRateLimited:
+		return "rate limited"
+	case StatusUnavailable:
+		return "unavailable"
+	case StatusTimeout:
+		return "timeout"
+	case StatusError:
+		return "error"
+	}
+	return "status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Credential kinds for OpAuth.
+const (
+	AuthAPIKey uint8 = iota + 1
+	AuthToken
+)
+
+const (
+	// headerSize is the length, id and code fields.
+	headerSize = 9
+	// MaxBatch is the most entries one OpBatch can carry.
+	MaxBatch = 1<<16 - 1
+)
+
+// ErrFrameTooLarge is returned for frames longer than the reader allows.
+var ErrFrameTooLarge = errors.New("binproto: frame too large")
+
+// Frame is one request or response.
+type Frame struct {
+	ID uint32
+	// Code is an Op in requests and a Status in responses.
+	Code uint8
+	Body []byte
+}
+
+// ReadFrame reads one frame from r, whose body may be at most max bytes.
+// For a longer frame it returns the frame's ID and code without its body
+// and ErrFrameTooLarge, leaving r part way through the frame.
+func ReadFrame(r io.Reader, max int) (Frame, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Frame{}, err
+	}
+	n := binary.BigEndian.Uint32(hdr[0:4])
+	if n < headerSize-4 {
+		return Frame{}, fmt.Errorf("binproto: frame length %d too short", n)
+	}
+	f := Frame{
+		ID:   binary.BigEndian.Uint32(hdr[4:8]),
+		Code: hdr[8],
+	}
+	if int64(n)-(headerSize-4) > int64(max) {
+		// The ID is kept so the caller can answer before giving up on
+		// the connection.
+		return f, ErrFrameTooLarge
+	}
+	f.Body = make([]byte, n-(headerSize-4))
+	if _, err := io.ReadFull(r, f.Body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+// AppendFrame appends the encoding of f to b.
+func AppendFrame(b []byte, f Frame) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(headerSize-4+len(f.Body)))
+	b = binary.BigEndian.AppendUint32(b, f.ID)
+	b = append(b, f.Code)
+	return append(b, f.Body...)
+}
+
+// Entry is one operation in a batch, or a single request decoded by
+// DecodeRequest.
+type Entry struct {
+	Op    Op
+	Key   int
+	Value string
+}
+
+// Result is the outcome of one batch entry.
+type Result struct {
+	Status Status
+	Body   []byte
+}
+
+// Err returns nil for StatusOK and the entry's error otherwise.
+func (r Result) Err() error {
+	if r.Status == StatusOK {
+		return nil
+	}
+	return &Error{Status: r.Status, Message: string(r.Body)}
+}
+
+// Value is the body of a successful get.
+func (r Result) Value() string { return string(r.Body) }
+
+// Version is the body of a successful put or delete.
+func (r Result) Version() uint64 {
+	v, _ := DecodeVersion(r.Body)
+	return v
+}
+
+// EncodeKey encodes the body of OpGet and OpDelete.
+func EncodeKey(key int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(key))
+}
+
+// EncodePut encodes the body of OpPut.
+func EncodePut(key int, value string) []byte {
+	b := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(value)), uint64(key))
+	return append(b, value...)
+}
+
+// DecodeRequest decodes the body of OpGet, OpPut and OpDelete.
+func DecodeRequest(op Op, body []byte) (Entry, error) {
+	if len(body) < 8 || (op != OpPut && len(body) != 8) {
+		return Entry{}, fmt.Errorf("malformed %s request", op)
+	}
+	e := Entry{Op: op, Key: int(int64(binary.BigEndian.Uint64(body)))}
+	if op == OpPut {
+		e.Value = string(body[8:])
+	}
+	return e, nil
+}
+
+// EncodeBatch encodes the body of OpBatch.
+func EncodeBatch(entries []Entry) ([]byte, error) {
+	if len(entries) > MaxBatch {
+		return nil, fmt.Errorf("binproto: batch of %d entries exceeds %d", len(entries), MaxBatch)
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(entries)))
+	for _, e := range entries {
+		switch e.Op {
+		case OpGet, OpPut, OpDelete:
+		default:
+			return nil, fmt.Errorf("binproto: %s cannot be batched", e.Op)
+		}
+		b = append(b, uint8(e.Op))
+		b = binary.BigEndian.AppendUint64(b, uint64(e.Key))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(e.Value)))
+		b = append(b, e.Value...)
+	}
+	return b, nil
+}
+
+// DecodeBatch decodes the body of OpBatch.
+func DecodeBatch(body []byte) ([]Entry, error) {
+	if len(body) < 2 {
+		return nil, errors.New("malformed batch request")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	entries := make([]Entry, 0, n)
+	for range n {
+		if len(body) < 13 {
+			return nil, errors.New("malformed batch request")
+		}
+		e := Entry{
+			Op:  Op(body[0]),
+			Key: int(int64(binary.BigEndian.Uint64(body[1:9]))),
+		}
+		size := binary.BigEndian.Uint32(body[9:13])
+		body = body[13:]
+		if uint64(size) > uint64(len(body)) {
+			return nil, errors.New("malformed batch request")
+		}
+		e.Value = string(body[:size])
+		body = body[size:]
+
+		switch e.Op {
+		case OpGet, OpPut, OpDelete:
+		default:
+			return nil, fmt.Errorf("%s cannot be batched", e.Op)
+		}
+		entries = append(entries, e)
+	}
+	if len(body) != 0 {
+		return nil, errors.New("malformed batch request")
+	}
+	return entries, nil
+}
+
+// EncodeBatchResults encodes the body of a response to OpBatch.
+func EncodeBatchResults(results []Result) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(results)))
+	for _, r := range results {
+		b = append(b, uint8(r.Status))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(r.Body)))
+		b = append(b, r.Body...)
+	}
+	return b
+}
+
+// DecodeBatchResults decodes the body of a response to OpBatch.
+func DecodeBatchResults(body []byte) ([]Result, error) {
+	if len(body) < 2 {
+		return nil, errors.New("binproto: malformed batch response")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	results := make([]Result, 0, n)
+	for range n {
+		if len(body) < 5 {
+			return nil, errors.New("binproto: malformed batch response")
+		}
+		r := Result{Status: Status(body[0])}
+		size := binary.BigEndian.Uint32(body[1:5])
+		body = body[5:]
+		if uint64(size) > uint64(len(body)) {
+			return nil, errors.New("binproto: malformed batch response")
+		}
+		r.Body = body[:size]
+		body = body[size:]
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// EncodeVersion encodes the body of a successful put or delete.
+func EncodeVersion(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// DecodeVersion decodes the body of a successful put or delete.
+func DecodeVersion(body []byte) (uint64, error) {
+	if len(body) != 8 {
+		return 0, errors.New("binproto: malformed version")
+	}
+	return binary.BigEndian.Uint64(body), nil
+}
+
+// Error is a response with a status other than StatusOK.
+type Error struct {
+	Status  Status
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "binproto: " + e.Status.String()
+	}
+	return "binproto: " + e.Status.String() + ": " + e.Message
+}
+
+// Is matches any *Error with the same status, so callers can test
+// errors.Is(err, binproto.ErrNotFound).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Status == e.Status
+}
+
+var (
+	ErrNotFound     = &Error{Status: StatusNotFound}
+	ErrUnauthorized = &Error{Status: StatusUnauthorized}
+	ErrForbidden    = &Error{Status: StatusForbidden}
+	ErrRateLimited  = &Error{Status: StatusRateLimited}
+	ErrUnavailable  = &Error{Status: StatusUnavailable}
+)
@@ -0,0 +1,189 @@
+package binproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{ID: 1, Code: uint8(OpGet), Body: EncodeKey(42)},
+		{ID: 1<<32 - 1, Code: uint8(OpPing), Body: []byte{}},
+		{ID: 7, Code: uint8(StatusOK), Body: bytes.Repeat([]byte("v"), 100)},
+	}
+	var buf []byte
+	for _, f := range frames {
+		buf = AppendFrame(buf, f)
+	}
+
+	r := bytes.NewReader(buf)
+	for _, want := range frames {
+		got, err := ReadFrame(r, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	if _, err := ReadFrame(r, 100); err != io.EOF {
+		t.Errorf("after the last frame: got %v, want EOF", err)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	full := AppendFrame(nil, Frame{ID: 9, Code: uint8(OpPut), Body: EncodePut(1, "value")})
+
+	// A body over the limit keeps the ID so the server can answer it.
+	f, err := ReadFrame(bytes.NewReader(full), 8)
+	if err != ErrFrameTooLarge || f.ID != 9 || f.Code != uint8(OpPut) {
+		t.Errorf("too large: got %+v, %v", f, err)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader(full[:len(full)-1]), 100); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated body: got %v, want ErrUnexpectedEOF", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(full[:4]), 100); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated header: got %v, want ErrUnexpectedEOF", err)
+	}
+	short := []byte{0, 0, 0, 4, 0, 0, 0, 1, 1}
+	if _, err := ReadFrame(bytes.NewReader(short), 100); err == nil {
+		t.Error("length shorter than the header accepted")
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	for _, tc := range []struct {
+		op      Op
+		body    []byte
+		want    Entry
+		wantErr bool
+	}{
+		{OpGet, EncodeKey(-5), Entry{Op: OpGet, Key: -5}, false},
+		{OpDelete, EncodeKey(7), Entry{Op: OpDelete, Key: 7}, false},
+		{OpPut, EncodePut(7, "v"), Entry{Op: OpPut, Key: 7, Value: "v"}, false},
+		{OpGet, EncodePut(7, "v"), Entry{}, true},
+		{OpGet, []byte{1, 2}, Entry{}, true},
+		{OpPut, []byte{1}, Entry{}, true},
+	} {
+		got, err := DecodeRequest(tc.op, tc.body)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%s %x: got %+v, %v; want %+v", tc.op, tc.body, got, err, tc.want)
+		}
+	}
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Op: OpPut, Key: 1, Value: "one"},
+		{Op: OpGet, Key: 1},
+		{Op: OpDelete, Key: -2},
+		{Op: OpPut, Key: 3, Value: ""},
+	}
+	body, err := EncodeBatch(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeBatch(body)
+	if err != nil || !reflect.DeepEqual(got, entries) {
+		t.Fatalf("got %+v, %v; want %+v", got, err, entries)
+	}
+
+	if got, err := DecodeBatch([]byte{0, 0}); err != nil || len(got) != 0 {
+		t.Errorf("empty batch: got %+v, %v", got, err)
+	}
+	for name, bad := range map[string][]byte{
+		"no count":      {0},
+		"short entry":   body[:10],
+		"short value":   body[:len(body)-1],
+		"trailing data": append(append([]byte{}, body...), 0),
+		"extra count":   append([]byte{0, 5}, body[2:]...),
+		"unbatchable":   {0, 1, uint8(OpAuth), 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0},
+	} {
+		if _, err := DecodeBatch(bad); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, err := EncodeBatch([]Entry{{Op: OpPing}}); err == nil {
+		t.Error("EncodeBatch accepted a ping")
+	}
+	if _, err := EncodeBatch(make([]Entry, MaxBatch+1)); err == nil {
+		t.Error("EncodeBatch accepted more than MaxBatch entries")
+	}
+
+	results := []Result{
+		{Status: StatusOK, Body: EncodeVersion(3)},
+		{Status: StatusNotFound, Body: []byte("key is not present")},
+		{Status: StatusOK, Body: []byte{}},
+	}
+	decoded, err := DecodeBatchResults(EncodeBatchResults(results))
+	if err != nil || !reflect.DeepEqual(decoded, results) {
+		t.Fatalf("results: got %+v, %v", decoded, err)
+	}
+	if decoded[0].Version() != 3 || decoded[0].Err() != nil {
+		t.Errorf("first result: version %d, err %v", decoded[0].Version(), decoded[0].Err())
+	}
+	if err := decoded[1].Err(); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+		t.Errorf("second result: %v", err)
+	}
+}
+
+// TestClientMatchesResponsesByID answers pipelined requests in reverse
+// order and checks each caller gets its own response.
+func TestClientMatchesResponsesByID(t *testing.T) {
+	client, server := net.Pipe()
+	c := NewClient(client)
+	defer c.Close()
+
+	const n = 3
+	go func() {
+		r := bufio.NewReader(server)
+		var requests []Frame
+		for range n {
+			f, err := ReadFrame(r, 100)
+			if err != nil {
+				return
+			}
+			requests = append(requests, f)
+		}
+		for i := n - 1; i >= 0; i-- {
+			key, _ := DecodeRequest(OpGet, requests[i].Body)
+			value := []byte{byte('a' + key.Key)}
+			server.Write(AppendFrame(nil, Frame{ID: requests[i].ID, Code: uint8(StatusOK), Body: value}))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	type result struct {
+		key   int
+		value string
+		err   error
+	}
+	results := make(chan result, n)
+	for key := range n {
+		go func() {
+			value, err := c.Get(ctx, key)
+			results <- result{key, value, err}
+		}()
+	}
+	for range n {
+		r := <-results
+		if want := string(rune('a' + r.key)); r.err != nil || r.value != want {
+			t.Errorf("key %d: got %q, %v; want %q", r.key, r.value, r.err, want)
+		}
+	}
+
+	// Requests in flight when the connection drops fail.
+	server.Close()
+	if _, err := c.Get(ctx, 1); err == nil {
+		t.Error("Get on a closed connection succeeded")
+	}
+}
@@ -0,0 +1,213 @@
+package binproto
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrClosed is returned for requests on a closed client, and for requests
+// in flight when the connection fails.
+var ErrClosed = errors.New("binproto: connection closed")
+
+// DefaultMaxFrame is the largest response body a Client reads.
+const DefaultMaxFrame = 16 << 20
+
+// Client is a connection to the binary protocol listener. It is safe for
+// concurrent use; concurrent requests are pipelined on the one connection.
+type Client struct {
+	conn net.Conn
+
+	// wmu serialises writes so frames are not interleaved.
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan Frame
+	err     error
+}
+
+// Dial connects to addr, over TLS if tlsConfig is not nil.
+func Dial(ctx context.Context, addr string, tlsConfig *tls.Config) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		cfg := tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient starts a client on an established connection.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		w:       bufio.NewWriter(conn),
+		pending: make(map[uint32]chan Frame),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Close closes the connection, failing requests still in flight.
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return c.conn.Close()
+}
+
+func (c *Client) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		f, err := ReadFrame(r, DefaultMaxFrame)
+		if err != nil {
+			c.fail(ErrClosed)
+			c.conn.Close()
+			return
+		}
+		c.mu.Lock()
+		ch, found := c.pending[f.ID]
+		delete(c.pending, f.ID)
+		c.mu.Unlock()
+		// Responses to requests given up on are dropped.
+		if found {
+			ch <- f
+		}
+	}
+}
+
+// fail ends every pending request with err.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Do sends one request and waits for its response, or for ctx to end.
+// Only a transport failure is returned as an error; the response may
+// carry any status.
+func (c *Client) Do(ctx context.Context, op Op, body []byte) (Frame, error) {
+	ch := make(chan Frame, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return Frame{}, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	c.w.Write(AppendFrame(nil, Frame{ID: id, Code: uint8(op), Body: body}))
+	err := c.w.Flush()
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(ErrClosed)
+		c.conn.Close()
+		return Frame{}, err
+	}
+
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return Frame{}, ErrClosed
+		}
+		return f, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return Frame{}, ctx.Err()
+	}
+}
+
+// call sends a request and turns an error status into an *Error.
+func (c *Client) call(ctx context.Context, op Op, body []byte) ([]byte, error) {
+	f, err := c.Do(ctx, op, body)
+	if err != nil {
+		return nil, err
+	}
+	if st := Status(f.Code); st != StatusOK {
+		return nil, &Error{Status: st, Message: string(f.Body)}
+	}
+	return f.Body, nil
+}
+
+// AuthAPIKey authenticates the connection with an API key and returns the
+// principal name. Later requests on the connection run as that principal.
+func (c *Client) AuthAPIKey(ctx context.Context, key string) (string, error) {
+	body, err := c.call(ctx, OpAuth, append([]byte{AuthAPIKey}, key...))
+	return string(body), err
+}
+
+// AuthToken authenticates the connection with a bearer token and returns
+// the principal name.
+func (c *Client) AuthToken(ctx context.Context, token string) (string, error) {
+	body, err := c.call(ctx, OpAuth, append([]byte{AuthToken}, token...))
+	return string(body), err
+}
+
+// Ping checks the connection.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.call(ctx, OpPing, nil)
+	return err
+}
+
+// Get returns the value for key, or an error matching ErrNotFound.
+func (c *Client) Get(ctx context.Context, key int) (string, error) {
+	body, err := c.call(ctx, OpGet, EncodeKey(key))
+	return string(body), err
+}
+
+// Put stores value under key and returns its new version.
+func (c *Client) Put(ctx context.Context, key int, value string) (uint64, error) {
+	body, err := c.call(ctx, OpPut, EncodePut(key, value))
+	if err != nil {
+		return 0, err
+	}
+	return DecodeVersion(body)
+}
+
+// Delete removes key and returns the version it had.
+func (c *Client) Delete(ctx context.Context, key int) (uint64, error) {
+	body, err := c.call(ctx, OpDelete, EncodeKey(key))
+	if err != nil {
+		return 0, err
+	}
+	return DecodeVersion(body)
+}
+
+// Batch runs entries in order in one round trip and returns a result for
+// each. A batch is not atomic: each entry succeeds or fails on its own.
+func (c *Client) Batch(ctx context.Context, entries []Entry) ([]Result, error) {
+	req, err := EncodeBatch(entries)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.call(ctx, OpBatch, req)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeBatchResults(body)
+}
@@ -0,0 +1,149 @@
+// Package breaker implements a circuit breaker: after enough consecutive
+// failures it rejects calls for a cool-down period, then lets a single
+// probe through to decide whether to close again.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half_open"
+	}
+	return "open"
+}
+
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	rejected uint64
+	opened   uint64
+}
+
+// New returns a closed breaker that opens after threshold consecutive
+// failures and stays open for cooldown.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Record or Cancel. When the call is rejected, Allow also returns how
+// long until the breaker will let a probe through.
+func (b *Breaker) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			b.rejected++
+			return false, wait
+		}
+		b.state = HalfOpen
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			b.rejected++
+			return false, 0
+		}
+		b.probing = true
+	}
+	return true, 0
+}
+
+// Record reports the outcome of an allowed call.
+func (b *Breaker) Record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen {
+		b.probing = false
+		if ok {
+			b.state, b.failures = Closed, 0
+		} else {
+			b.trip()
+		}
+		return
+	}
+
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == Closed && b.failures >= b.threshold {
+		b.trip()
+	}
+}
+
+// Cancel reports that an allowed call ended without saying anything about
+// the protected service's health, e.g. because its caller gave up.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *Breaker) trip() {
+	b.state = Open
+	b.openedAt = time.Now()
+	b.opened++
+}
+
+// State returns the current state. An open breaker whose cool-down has
+// passed reports HalfOpen.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// RetryAfter returns how long until an open breaker lets a probe through,
+// or 0.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Open {
+		return 0
+	}
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
+
+// Stats counts calls rejected and times the breaker has opened.
+type Stats struct {
+	Rejected uint64
+	Opened   uint64
+}
+
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Stats{Rejected: b.rejected, Opened: b.opened}
+}
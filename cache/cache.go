@@ -2,12 +2,22 @@ package cache
 
 import (
 	"container/list"
+	"sync"
 )
 
 type LRUCache struct {
+	mu       sync.Mutex
 	capacity int
 	cache    map[string]*list.Element
 	order    *list.List
+	stats    Stats
+}
+
+// Stats counts cache activity since the cache was created.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
 }
 
 type cacheEntry struct {
@@ -24,14 +34,26 @@ func NewLRUCache(capacity int) *LRUCache {
 }
 
 func (c *LRUCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if elem, found := c.cache[key]; found {
 		c.order.MoveToFront(elem)
+		c.stats.Hits++
 		return elem.Value.(*cacheEntry).value, true
 	}
+	c.stats.Misses++
 	return "", false
 }
 
 func (c *LRUCache) Put(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.capacity <= 0 {
+		return
+	}
+
 	if elem, found := c.cache[key]; found {
 		c.order.MoveToFront(elem)
 		elem.Value.(*cacheEntry).value = value
@@ -52,12 +74,65 @@ func (c *LRUCache) evict() {
 	if elem != nil {
 		c.order.Remove(elem)
 		delete(c.cache, elem.Value.(*cacheEntry).key)
+		c.stats.Evictions++
 	}
 }
 
 func (c *LRUCache) DeleteKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if elem, found := c.cache[key]; found {
 		c.order.Remove(elem)
 		delete(c.cache, key)
 	}
 }
+
+// Clear drops every entry, returning how many there were. The counters
+// are kept.
+func (c *LRUCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.order.Len()
+	c.cache = make(map[string]*list.Element)
+	c.order.Init()
+	return n
+}
+
+// Keys returns up to n keys in LRU order, most recently used first.
+// A negative n returns every key.
+func (c *LRUCache) Keys(n int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n < 0 || n > c.order.Len() {
+		n = c.order.Len()
+	}
+	keys := make([]string, 0, n)
+	for elem := c.order.Front(); elem != nil && len(keys) < n; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*cacheEntry).key)
+	}
+	return keys
+}
+
+// Len returns the number of entries currently cached.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// Capacity returns the maximum number of entries the cache holds.
+func (c *LRUCache) Capacity() int {
+	return c.capacity
+}
+
+// Stats returns the hit, miss and eviction counters.
+func (c *LRUCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
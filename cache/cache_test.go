@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestLRUCacheConcurrentAccess(t *testing.T) {
+	const (
+		workers  = 8
+		capacity = 50
+		keys     = 200
+	)
+	c := NewLRUCache(capacity)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := strconv.Itoa((w*31 + i) % keys)
+				switch i % 4 {
+				case 0, 1:
+					c.Put(key, "v"+key)
+				case 2:
+					if v, ok := c.Get(key); ok && v != "v"+key {
+						t.Errorf("Get(%s) = %q", key, v)
+					}
+				case 3:
+					c.DeleteKey(key)
+				}
+				if i%100 == 0 {
+					c.Keys(10)
+					c.Stats()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := c.Len(); n > capacity {
+		t.Fatalf("Len() = %d, over capacity %d", n, capacity)
+	}
+	if got := len(c.Keys(-1)); got != c.Len() {
+		t.Fatalf("Keys(-1) has %d keys, Len() = %d", got, c.Len())
+	}
+	st := c.Stats()
+	if st.Hits+st.Misses != workers*250 {
+		t.Errorf("hits %d + misses %d, want %d lookups", st.Hits, st.Misses, workers*250)
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Put("1", "a")
+	c.Put("2", "b")
+	c.Get("1")
+	c.Put("3", "c")
+
+	if _, ok := c.Get("2"); ok {
+		t.Error("2 was not evicted")
+	}
+	if got := c.Keys(-1); !slices.Equal(got, []string{"3", "1"}) {
+		t.Errorf("Keys(-1) = %v, want [3 1]", got)
+	}
+	if st := c.Stats(); st.Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", st.Evictions)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	c := NewLRUCache(10)
+	for _, key := range []string{"1", "2", "3", "4"} {
+		c.Put(key, "v")
+	}
+	c.Get("2")
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	if err := c.SaveSnapshot(path, 3); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2", "4", "3"}; !slices.Equal(keys, want) {
+		t.Fatalf("LoadSnapshot = %v, want %v", keys, want)
+	}
+
+	// Saving again replaces the file and leaves no temporary files.
+	if err := NewLRUCache(10).SaveSnapshot(path, 3); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := LoadSnapshot(path); err != nil || len(keys) != 0 {
+		t.Fatalf("LoadSnapshot of an empty cache = %v, %v", keys, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the snapshot", len(entries))
+	}
+
+	if _, err := LoadSnapshot(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("LoadSnapshot of a missing file: %v", err)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"bytes"
 	"time"
 	"strconv"
+	"flag"
+
+	"decsproject/tlsconfig"
 )
 
 type keyValue struct{
@@ -18,16 +21,44 @@ type keyValue struct{
 
 var n int = 15
 
+var (
+	urlFlag      = flag.String("url", "http://localhost:8080", "server base URL; use https:// for a TLS listener")
+	apiKeyFlag   = flag.String("api-key", "", "API key sent in the X-API-Key header")
+	tokenFlag    = flag.String("token", "", "bearer token sent in the Authorization header")
+	caFlag       = flag.String("cacert", "", "PEM CA bundle to verify the server certificate (default: system roots)")
+	certFlag     = flag.String("cert", "", "PEM client certificate for mutual TLS")
+	tlsKeyFlag   = flag.String("tls-key", "", "PEM private key for -cert")
+	insecureFlag = flag.Bool("insecure", false, "skip verification of the server certificate")
+)
+
+var client = &http.Client{}
+
+// post sends body to url with the credentials given on the command line.
+func post(url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if *apiKeyFlag != "" {
+		req.Header.Set("X-API-Key", *apiKeyFlag)
+	}
+	if *tokenFlag != "" {
+		req.Header.Set("Authorization", "Bearer "+*tokenFlag)
+	}
+	return client.Do(req)
+}
+
 func putKeyValue(key int, value string) {
 	m := keyValue{Key : key, Value : value}
 	jsonData, err := json.Marshal(m)
 	if err != nil {
         panic(err)
     }
-	url := "http://localhost:8080/put"
+	url := *urlFlag + "/put"
 	contentType:="application/json"
 
-	resp, err := http.Post(url, contentType, bytes.NewReader(jsonData))
+	resp, err := post(url, contentType, bytes.NewReader(jsonData))
 	if err != nil {
         panic(err)
     }
@@ -47,10 +78,10 @@ func getValue(key int) {
 		panic(err)
 	}
 
-	url:="http://localhost:8080/get"
+	url:=*urlFlag + "/get"
 	contentType:="application/json"
 
-	resp, err:= http.Post(url, contentType, bytes.NewReader(jsonData))
+	resp, err:= post(url, contentType, bytes.NewReader(jsonData))
 	if err!=nil{
 		panic(err)
 	}
@@ -72,10 +103,10 @@ func deleteKey(key int) {
 		panic(err)
 	}
 
-	url := "http://localhost:8080/delete"
+	url := *urlFlag + "/delete"
 	contentType :="application/json"
 
-	resp, err := http.Post(url, contentType, bytes.NewReader(jsonData))
+	resp, err := post(url, contentType, bytes.NewReader(jsonData))
 	if err != nil {
         panic(err)
     }
@@ -90,15 +121,21 @@ func deleteKey(key int) {
 }
 
 func main() {
+	flag.Parse()
+
+	tlsConfig, err := tlsconfig.Client(*caFlag, *certFlag, *tlsKeyFlag, *insecureFlag)
+	if err != nil {
+		panic(err)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
 	
-	
-    resp, err := http.Get("http://localhost:8080/hello")
+    resp, err := client.Get(*urlFlag + "/hello")
     if err != nil {
         panic(err)
     }
     defer resp.Body.Close()
 
-	fmt.Printf("Response status: %d\n", resp.Status)
+	fmt.Printf("Response status: %s\n", resp.Status)
 
 	//reading hello
 	scanner :=bufio.NewScanner(resp.Body)
@@ -0,0 +1,47 @@
+// Command kvtoken mints HMAC-signed bearer tokens accepted by the server
+// when auth.jwt is configured.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"decsproject/auth"
+)
+
+func main() {
+	var (
+		secretFlag     = flag.String("secret", "", "HMAC secret (or use -secret-file)")
+		secretFileFlag = flag.String("secret-file", "", "file holding the HMAC secret")
+		subjectFlag    = flag.String("sub", "", "principal name (sub claim)")
+		scopeFlag      = flag.String("scope", "read write", "space-separated scopes")
+		ttlFlag        = flag.Duration("ttl", 24*time.Hour, "token lifetime (0 = no expiry)")
+		issuerFlag     = flag.String("iss", "", "iss claim")
+		audienceFlag   = flag.String("aud", "", "aud claim")
+	)
+	flag.Parse()
+
+	secret := []byte(*secretFlag)
+	if *secretFileFlag != "" {
+		b, err := os.ReadFile(*secretFileFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		secret = []byte(strings.TrimSpace(string(b)))
+	}
+	if len(secret) == 0 || *subjectFlag == "" {
+		fmt.Fprintln(os.Stderr, "-secret or -secret-file, and -sub are required")
+		os.Exit(1)
+	}
+
+	token, err := auth.NewToken(secret, *subjectFlag, strings.Fields(*scopeFlag), *ttlFlag, *issuerFlag, *audienceFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
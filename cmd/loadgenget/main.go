@@ -0,0 +1,267 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+    "runtime"
+    "flag"
+    "os"
+
+    "decsproject/binproto"
+    "decsproject/tlsconfig"
+)
+
+func main() {
+    var (
+        urlFlag        = flag.String("url", "http://localhost:8080/get", "request BASE URL (key will be added as ?key=X)")
+        threadsFlag    = flag.Int("threads", 10, "number of concurrent client workers (closed-loop users)")
+        durationFlag   = flag.Duration("duration", 300*time.Second, "test duration (e.g. 300s)")
+        reqTimeoutFlag = flag.Duration("reqtimeout", 5*time.Second, "per-request timeout")
+        keyFlag        = flag.Int("key", 5, "fixed key value to use in the URL query parameter (?key=X)")
+        apiKeyFlag     = flag.String("api-key", "", "API key sent in the X-API-Key header")
+        tokenFlag      = flag.String("token", "", "bearer token sent in the Authorization header")
+        caFlag         = flag.String("cacert", "", "PEM CA bundle to verify the server certificate (default: system roots)")
+        certFlag       = flag.String("cert", "", "PEM client certificate for mutual TLS")
+        tlsKeyFlag     = flag.String("tls-key", "", "PEM private key for -cert")
+        insecureFlag   = flag.Bool("insecure", false, "skip verification of the server certificate")
+        protoFlag      = flag.String("proto", "auto", "HTTP protocol: auto (HTTP/2 if TLS negotiates it), http1, or http2 (h2c for http:// URLs)")
+        binaryFlag     = flag.String("binary", "", "binary protocol address; when set, requests are pipelined on one connection instead of sent over HTTP")
+    )
+    flag.Parse()
+
+    target := fmt.Sprintf("%s?key=%d", *urlFlag, *keyFlag)
+    if *binaryFlag != "" {
+        target = fmt.Sprintf("binary://%s key=%d", *binaryFlag, *keyFlag)
+    }
+    fmt.Printf("Load generator\n  URL=%s\n  threads=%d\n  duration=%s\n  Timeout=%s\n  proto=%s\n\n",
+        target, *threadsFlag, durationFlag.String(), reqTimeoutFlag.String(), *protoFlag)
+
+    runtime.GOMAXPROCS(runtime.NumCPU())
+
+    tlsConfig, err := tlsconfig.Client(*caFlag, *certFlag, *tlsKeyFlag, *insecureFlag)
+    if err != nil {
+        fmt.Println("tls:", err)
+        os.Exit(1)
+    }
+    protocols, err := httpProtocols(*protoFlag)
+    if err != nil {
+        fmt.Println("proto:", err)
+        os.Exit(1)
+    }
+
+    transport := &http.Transport{
+        Protocols:       protocols,
+        TLSClientConfig: tlsConfig,
+        Proxy: http.ProxyFromEnvironment,
+        DialContext: (&net.Dialer{
+            Timeout:   5 * time.Second,
+            KeepAlive: 30 * time.Second,
+        }).DialContext,
+        ForceAttemptHTTP2:     true,
+        MaxIdleConns:          1000,
+        MaxIdleConnsPerHost:   1000,
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   5 * time.Second,
+        ExpectContinueTimeout: 1 * time.Second,
+    }
+    client := &http.Client{
+        Transport: transport,
+        Timeout:   *reqTimeoutFlag,
+    }
+
+    var bin *binproto.Client
+    if *binaryFlag != "" {
+        bin, err = dialBinary(*binaryFlag, tlsConfig, *caFlag != "" || *certFlag != "" || *insecureFlag, *apiKeyFlag, *tokenFlag)
+        if err != nil {
+            fmt.Println("binary:", err)
+            os.Exit(1)
+        }
+        defer bin.Close()
+    }
+
+    var totalRequests uint64
+    var totalSuccess uint64
+    var totalErrors uint64
+    var totalLatencyNs uint64 
+    var protoCounts sync.Map // resp.Proto -> *uint64
+
+    requestURL := fmt.Sprintf("%s?key=%d", *urlFlag, *keyFlag)
+
+    stop := make(chan struct{})
+
+    var wg sync.WaitGroup
+    wg.Add(*threadsFlag)
+
+    startTime := time.Now()
+    for i := 0; i < *threadsFlag; i++ {
+        go func(workerID int) {
+            defer wg.Done()
+            for {
+                select {
+                case <-stop:
+                    return
+                default:
+                    ctx, cancel := context.WithTimeout(context.Background(), *reqTimeoutFlag)
+
+                    if bin != nil {
+                        t0 := time.Now()
+                        _, err := bin.Get(ctx, *keyFlag)
+                        lat := time.Since(t0)
+                        cancel()
+                        atomic.AddUint64(&totalRequests, 1)
+                        atomic.AddUint64(&totalLatencyNs, uint64(lat.Nanoseconds()))
+                        if err != nil {
+                            atomic.AddUint64(&totalErrors, 1)
+                        } else {
+                            atomic.AddUint64(&totalSuccess, 1)
+                        }
+                        continue
+                    }
+
+                    req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+                    if err != nil {
+                        atomic.AddUint64(&totalErrors, 1)
+                        atomic.AddUint64(&totalRequests, 1)
+                        cancel()
+                        time.Sleep(5 * time.Millisecond)
+                        continue
+                    }
+                    if *apiKeyFlag != "" {
+                        req.Header.Set("X-API-Key", *apiKeyFlag)
+                    }
+                    if *tokenFlag != "" {
+                        req.Header.Set("Authorization", "Bearer "+*tokenFlag)
+                    }
+
+                    t0 := time.Now()
+                    resp, err := client.Do(req)
+                    lat := time.Since(t0)
+                    atomic.AddUint64(&totalRequests, 1)
+                    atomic.AddUint64(&totalLatencyNs, uint64(lat.Nanoseconds()))
+
+                    if err != nil {
+                        atomic.AddUint64(&totalErrors, 1)
+                        cancel()
+                        time.Sleep(10 * time.Millisecond)
+                        continue
+                    }
+
+                    _, _ = io.ReadAll(resp.Body)
+                    resp.Body.Close()
+                    countProto(&protoCounts, resp.Proto)
+
+                    if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+                        atomic.AddUint64(&totalSuccess, 1)
+                    } else {
+                        atomic.AddUint64(&totalErrors, 1)
+                    }
+                    cancel()
+                }
+            }
+        }(i)
+    }
+
+    time.Sleep(*durationFlag)
+    close(stop)
+    wg.Wait()
+
+    elapsed := time.Since(startTime)
+    totalReq := atomic.LoadUint64(&totalRequests)
+    successReq := atomic.LoadUint64(&totalSuccess)
+    errReq := atomic.LoadUint64(&totalErrors)
+    sumLatencyNs := atomic.LoadUint64(&totalLatencyNs)
+
+    throughput := float64(successReq) / elapsed.Seconds()
+    avgLatencyMs := 0.0
+    if totalReq > 0 {
+        avgLatencyMs = float64(sumLatencyNs) / float64(totalReq) / 1e6
+    }
+
+    fmt.Println("Load test summary")
+    fmt.Printf("Duration: %s\n", elapsed)
+    fmt.Printf("Threads: %d\n", *threadsFlag)
+    fmt.Printf("Total requests: %d (success=%d, errors=%d)\n", totalReq, successReq, errReq)
+    fmt.Printf("Throughput (successful req/s): %.2f\n", throughput)
+    fmt.Printf("Average latency (ms, across all requests): %.3f\n", avgLatencyMs)
+    fmt.Printf("Requests/sec (all): %.2f\n", float64(totalReq)/elapsed.Seconds())
+    if bin == nil {
+        fmt.Printf("Negotiated protocols: %s\n", formatProtos(&protoCounts))
+    }
+    fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+}
+
+// dialBinary connects to the binary protocol listener, over TLS if any TLS
+// flag was given, and authenticates with the API key or token if one was.
+func dialBinary(addr string, tlsConfig *tls.Config, useTLS bool, apiKey, token string) (*binproto.Client, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    if !useTLS {
+        tlsConfig = nil
+    }
+    c, err := binproto.Dial(ctx, addr, tlsConfig)
+    if err != nil {
+        return nil, err
+    }
+    switch {
+    case apiKey != "":
+        _, err = c.AuthAPIKey(ctx, apiKey)
+    case token != "":
+        _, err = c.AuthToken(ctx, token)
+    }
+    if err != nil {
+        c.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// httpProtocols returns the transport protocols for -proto; nil leaves the
+// transport to negotiate HTTP/2 over TLS and use HTTP/1.1 otherwise.
+func httpProtocols(mode string) (*http.Protocols, error) {
+    p := new(http.Protocols)
+    switch mode {
+    case "auto":
+        return nil, nil
+    case "http1":
+        p.SetHTTP1(true)
+    case "http2":
+        // Without HTTP1 the transport speaks h2c with prior knowledge to
+        // http:// URLs.
+        p.SetHTTP2(true)
+        p.SetUnencryptedHTTP2(true)
+    default:
+        return nil, fmt.Errorf("%q: want auto, http1 or http2", mode)
+    }
+    return p, nil
+}
+
+// countProto counts one response received over proto, e.g. "HTTP/2.0".
+func countProto(counts *sync.Map, proto string) {
+    n, _ := counts.LoadOrStore(proto, new(uint64))
+    atomic.AddUint64(n.(*uint64), 1)
+}
+
+// formatProtos lists the response count of each protocol seen, e.g.
+// "HTTP/2.0=1200".
+func formatProtos(counts *sync.Map) string {
+    var parts []string
+    counts.Range(func(proto, n any) bool {
+        parts = append(parts, fmt.Sprintf("%s=%d", proto, atomic.LoadUint64(n.(*uint64))))
+        return true
+    })
+    if len(parts) == 0 {
+        return "none"
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ", ")
+}
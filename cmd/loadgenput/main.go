@@ -11,9 +11,13 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"decsproject/tlsconfig"
 )
 
 type keyValue struct {
@@ -28,6 +32,13 @@ func main() {
 		durationFlag = flag.Duration("duration", 60*time.Second, "test duration")
 		keyCountFlag = flag.Int("keycount", 100, "number of unique keys to randomly choose from")
 		timeoutFlag  = flag.Duration("timeout", 5*time.Second, "per-request timeout")
+		apiKeyFlag   = flag.String("api-key", "", "API key sent in the X-API-Key header")
+		tokenFlag    = flag.String("token", "", "bearer token sent in the Authorization header")
+		caFlag       = flag.String("cacert", "", "PEM CA bundle to verify the server certificate (default: system roots)")
+		certFlag     = flag.String("cert", "", "PEM client certificate for mutual TLS")
+		tlsKeyFlag   = flag.String("tls-key", "", "PEM private key for -cert")
+		insecureFlag = flag.Bool("insecure", false, "skip verification of the server certificate")
+		protoFlag    = flag.String("proto", "http1", "HTTP protocol: auto (HTTP/2 if TLS negotiates it), http1, or http2 (h2c for http:// URLs)")
 	)
 	flag.Parse()
 
@@ -37,8 +48,8 @@ func main() {
 	}
 
 	fmt.Printf("\nLoad Generator\n")
-	fmt.Printf("URL: %s\nThreads: %d\nDuration: %s\nKeyCount: %d\nTimeout: %s\n\n",
-		*urlFlag, *threadsFlag, durationFlag.String(), *keyCountFlag, timeoutFlag.String())
+	fmt.Printf("URL: %s\nThreads: %d\nDuration: %s\nKeyCount: %d\nTimeout: %s\nProto: %s\n\n",
+		*urlFlag, *threadsFlag, durationFlag.String(), *keyCountFlag, timeoutFlag.String(), *protoFlag)
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -49,11 +60,24 @@ func main() {
 		jsonBodies[i-1] = b
 	}
 
+	tlsConfig, err := tlsconfig.Client(*caFlag, *certFlag, *tlsKeyFlag, *insecureFlag)
+	if err != nil {
+		fmt.Println("tls:", err)
+		os.Exit(1)
+	}
+	protocols, err := httpProtocols(*protoFlag)
+	if err != nil {
+		fmt.Println("proto:", err)
+		os.Exit(1)
+	}
+
 	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		Protocols:           protocols,
 		MaxIdleConns:        1000,
 		MaxIdleConnsPerHost: 1000,
 		IdleConnTimeout:     90 * time.Second,
-		ForceAttemptHTTP2:   false,
+		ForceAttemptHTTP2:   true,
 		DialContext: (&net.Dialer{
 			Timeout:   5 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -69,6 +93,7 @@ func main() {
 	var success uint64
 	var errors uint64
 	var latencyNs uint64
+	var protoCounts sync.Map // resp.Proto -> *uint64
 
 	stop := make(chan struct{})
 	var wg sync.WaitGroup
@@ -95,6 +120,12 @@ func main() {
 						continue
 					}
 					req.Header.Set("Content-Type", "application/json")
+					if *apiKeyFlag != "" {
+						req.Header.Set("X-API-Key", *apiKeyFlag)
+					}
+					if *tokenFlag != "" {
+						req.Header.Set("Authorization", "Bearer "+*tokenFlag)
+					}
 
 					start := time.Now()
 					resp, err := client.Do(req)
@@ -111,6 +142,7 @@ func main() {
 
 					io.Copy(io.Discard, resp.Body)
 					resp.Body.Close()
+					countProto(&protoCounts, resp.Proto)
 
 					if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 						atomic.AddUint64(&success, 1)
@@ -142,4 +174,46 @@ func main() {
 	fmt.Printf("Throughput (successful req/s): %.2f\n", throughput)
 	fmt.Printf("Average Latency: %.3f ms\n", avgLatency)
 	fmt.Printf("Requests/sec (all): %.2f\n", float64(totalReq)/durationFlag.Seconds())
+	fmt.Printf("Negotiated Protocols: %s\n", formatProtos(&protoCounts))
+}
+
+// httpProtocols returns the transport protocols for -proto; nil leaves the
+// transport to negotiate HTTP/2 over TLS and use HTTP/1.1 otherwise.
+func httpProtocols(mode string) (*http.Protocols, error) {
+	p := new(http.Protocols)
+	switch mode {
+	case "auto":
+		return nil, nil
+	case "http1":
+		p.SetHTTP1(true)
+	case "http2":
+		// Without HTTP1 the transport speaks h2c with prior knowledge to
+		// http:// URLs.
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("%q: want auto, http1 or http2", mode)
+	}
+	return p, nil
+}
+
+// countProto counts one response received over proto, e.g. "HTTP/2.0".
+func countProto(counts *sync.Map, proto string) {
+	n, _ := counts.LoadOrStore(proto, new(uint64))
+	atomic.AddUint64(n.(*uint64), 1)
+}
+
+// formatProtos lists the response count of each protocol seen, e.g.
+// "HTTP/2.0=1200".
+func formatProtos(counts *sync.Map) string {
+	var parts []string
+	counts.Range(func(proto, n any) bool {
+		parts = append(parts, fmt.Sprintf("%s=%d", proto, atomic.LoadUint64(n.(*uint64))))
+		return true
+	})
+	if len(parts) == 0 {
+		return "none"
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,52 @@
+// Command otlpsink is a stand-in OpenTelemetry collector for local runs. It
+// accepts OTLP/HTTP JSON trace exports and prints one line per span.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+type exportRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []json.RawMessage `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func main() {
+	addr := flag.String("addr", ":4318", "address to listen on")
+	flag.Parse()
+
+	http.HandleFunc("/v1/traces", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body exportRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			return
+		}
+
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					fmt.Fprintln(os.Stdout, string(span))
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	})
+
+	log.Printf("OTLP sink listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"decsproject/config"
+	"decsproject/logging"
+	"decsproject/server"
+	"decsproject/store"
+	"decsproject/tracing"
+)
+
+func main() {
+	cfg, err := config.Load(config.Default(), os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	slog.SetDefault(logging.New(os.Stdout, cfg.Log.SlogLevel()))
+
+	tracer, err := newTracer(cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	if tracer != nil {
+		tracing.SetDefault(tracer)
+		defer tracer.Shutdown(context.Background())
+	}
+
+	st, err := store.Open(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+
+	if err := st.Ping(context.Background()); err != nil {
+		log.Fatalf("Database connection error: %v", err)
+	}
+
+	s, err := server.New(cfg, st)
+	if err != nil {
+		log.Fatalf("Failed to set up server: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Restore the default handlers so a second signal kills the
+		// process without waiting for the drain to finish.
+		<-ctx.Done()
+		stop()
+	}()
+
+	go s.WarmUp(ctx)
+
+	if err := s.ListenAndServe(ctx); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Server stopped")
+}
+
+// newTracer builds the tracer selected by cfg, or returns nil when tracing
+// is disabled.
+func newTracer(cfg config.TracingConfig) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exporter = tracing.NewWriterExporter(f)
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Endpoint, cfg.ServiceName)
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", cfg.Exporter)
+	}
+	return tracing.NewTracer(exporter, cfg.SampleRate), nil
+}
@@ -0,0 +1,849 @@
+// Package config loads the key value server's runtime settings.
+//
+// Settings are resolved in increasing order of precedence from built-in
+// defaults, an optional YAML or TOML file, KV_* environment variables and
+// command-line flags, then validated as a whole.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"decsproject/inject"
+	"decsproject/ratelimit"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to every environment variable name.
+const EnvPrefix = "KV_"
+
+type Config struct {
+	ListenAddr string             `yaml:"listen_addr" toml:"listen_addr"`
+	Server     ServerConfig       `yaml:"server" toml:"server"`
+	TLS        TLSConfig          `yaml:"tls" toml:"tls"`
+	Admin      AdminConfig        `yaml:"admin" toml:"admin"`
+	Binary     BinaryConfig       `yaml:"binary" toml:"binary"`
+	RESP       RESPConfig         `yaml:"resp" toml:"resp"`
+	Memcache   MemcacheConfig     `yaml:"memcache" toml:"memcache"`
+	GRPC       GRPCConfig         `yaml:"grpc" toml:"grpc"`
+	Storage    StorageConfig      `yaml:"storage" toml:"storage"`
+	Cache      CacheConfig        `yaml:"cache" toml:"cache"`
+	Inject     inject.State       `yaml:"inject" toml:"inject"`
+	Log        LogConfig          `yaml:"log" toml:"log"`
+	Tracing    TracingConfig      `yaml:"tracing" toml:"tracing"`
+	Auth       AuthConfig         `yaml:"auth" toml:"auth"`
+	ACL        ACLConfig          `yaml:"acl" toml:"acl"`
+	RateLimit  ratelimit.Settings `yaml:"rate_limit" toml:"rate_limit"`
+	Quotas     []Quota            `yaml:"quotas" toml:"quotas"`
+	Audit      AuditConfig        `yaml:"audit" toml:"audit"`
+}
+
+// AuditConfig enables the mutation audit log when File is set.
+type AuditConfig struct {
+	File string `yaml:"file" toml:"file"`
+	// MaxSize is the size in bytes at which the file is rotated, keeping
+	// MaxBackups old files.
+	MaxSize    int `yaml:"max_size" toml:"max_size"`
+	MaxBackups int `yaml:"max_backups" toml:"max_backups"`
+}
+
+// Quota caps the keys, and the total value bytes, stored in a namespace:
+// the keys whose decimal form matches any of the Keys glob patterns
+// (path.Match syntax, as in the ACL). Zero means no limit.
+type Quota struct {
+	Namespace string   `json:"namespace" yaml:"namespace" toml:"namespace"`
+	Keys      []string `json:"keys" yaml:"keys" toml:"keys"`
+	MaxKeys   int      `json:"max_keys,omitempty" yaml:"max_keys" toml:"max_keys"`
+	MaxBytes  int64    `json:"max_bytes,omitempty" yaml:"max_bytes" toml:"max_bytes"`
+}
+
+type ACLConfig struct {
+	// File is a YAML policy mapping principals to operations on key
+	// patterns; empty allows every principal everything.
+	File string `yaml:"file" toml:"file"`
+	// ReloadInterval is how often File is checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+type AuthConfig struct {
+	// Enabled requires credentials on /get, /put and /delete.
+	Enabled bool      `yaml:"enabled" toml:"enabled"`
+	APIKeys []APIKey  `yaml:"api_keys" toml:"api_keys"`
+	JWT     JWTConfig `yaml:"jwt" toml:"jwt"`
+}
+
+// APIKey is a static credential sent in the X-API-Key header. Scopes
+// limit it to "read", "write" or "admin"; an empty list allows all three.
+type APIKey struct {
+	Name   string   `json:"name" yaml:"name" toml:"name"`
+	Key    string   `json:"key" yaml:"key" toml:"key"`
+	Scopes []string `json:"scopes,omitempty" yaml:"scopes" toml:"scopes"`
+}
+
+// JWTConfig enables HMAC-signed bearer tokens. The secret is given inline
+// or read from SecretFile; Issuer and Audience are checked when set.
+type JWTConfig struct {
+	Secret     string `yaml:"secret" toml:"secret"`
+	SecretFile string `yaml:"secret_file" toml:"secret_file"`
+	Issuer     string `yaml:"issuer" toml:"issuer"`
+	Audience   string `yaml:"audience" toml:"audience"`
+}
+
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+	// SampleRate is the fraction of access log lines written for requests
+	// that did not fail with a 5xx status; failures are always logged.
+	SampleRate float64 `yaml:"sample_rate" toml:"sample_rate"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout, file (JSON lines appended to File) or otlp
+	// (OTLP/HTTP JSON posted to Endpoint).
+	Exporter    string `yaml:"exporter" toml:"exporter"`
+	File        string `yaml:"file" toml:"file"`
+	Endpoint    string `yaml:"endpoint" toml:"endpoint"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+	// SampleRate is the fraction of new traces recorded; traces continued
+	// from a client's traceparent follow the client's sampling decision.
+	SampleRate float64 `yaml:"sample_rate" toml:"sample_rate"`
+}
+
+// SlogLevel returns the parsed log level; Validate guarantees it parses.
+func (c LogConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level))
+	return level
+}
+
+type ServerConfig struct {
+	// GetKey selects where /get reads its key from: "body" (JSON),
+	// "query" (?key=) or "auto" to use the query parameter when present.
+	GetKey string `yaml:"get_key" toml:"get_key"`
+	// ShutdownTimeout bounds how long in-flight requests are allowed to
+	// drain after SIGINT or SIGTERM before their connections are closed.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// MaxBodyBytes bounds a request body; larger bodies get 413.
+	MaxBodyBytes int `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	// MaxKeyBytes bounds the decimal form of a key and MaxValueBytes a
+	// value.
+	MaxKeyBytes   int `yaml:"max_key_bytes" toml:"max_key_bytes"`
+	MaxValueBytes int `yaml:"max_value_bytes" toml:"max_value_bytes"`
+	// Connection timeouts of the public listener; 0 disables one.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// HTTP2 serves HTTP/2 next to HTTP/1.1: negotiated through ALPN over
+	// TLS, and as h2c (prior knowledge) on a plaintext listener.
+	HTTP2 bool `yaml:"http2" toml:"http2"`
+	// HTTP2MaxConcurrentStreams bounds the requests one HTTP/2 connection
+	// may have in flight.
+	HTTP2MaxConcurrentStreams int `yaml:"http2_max_concurrent_streams" toml:"http2_max_concurrent_streams"`
+	// RequestTimeout is the deadline for handling a request, including its
+	// storage calls, unless EndpointTimeouts has one for the path.
+	RequestTimeout   time.Duration `yaml:"request_timeout" toml:"request_timeout"`
+	EndpointTimeouts Durations     `yaml:"endpoint_timeouts" toml:"endpoint_timeouts"`
+}
+
+// Durations maps names to durations, written as strings such as "250ms"
+// in JSON.
+type Durations map[string]time.Duration
+
+func (d Durations) MarshalJSON() ([]byte, error) {
+	out := make(map[string]string, len(d))
+	for k, v := range d {
+		out[k] = v.String()
+	}
+	return json.Marshal(out)
+}
+
+func (d *Durations) UnmarshalJSON(data []byte) error {
+	var in map[string]string
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	out := make(Durations, len(in))
+	for k, v := range in {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		out[k] = dur
+	}
+	*d = out
+	return nil
+}
+
+// TLSConfig enables HTTPS on listen_addr when CertFile and KeyFile are
+// set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	// ClientCAFile verifies client certificates; ClientAuth is none,
+	// request (verify if presented) or require.
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth" toml:"client_auth"`
+	// ReloadInterval is how often the certificate files are checked for
+	// rotation.
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+type AdminConfig struct {
+	// ListenAddr is where pprof, runtime and cache introspection and the
+	// tuning endpoints are served; empty disables them.
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+}
+
+// BinaryConfig configures the binary protocol listener, which serves the
+// same operations as the HTTP API over pipelined length-prefixed frames.
+type BinaryConfig struct {
+	// ListenAddr is empty to disable the listener.
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	// MaxFrameBytes bounds the body of one request frame; longer frames
+	// close the connection.
+	MaxFrameBytes int `yaml:"max_frame_bytes" toml:"max_frame_bytes"`
+	// MaxInFlight is how many requests one connection may have running
+	// before the server stops reading from it.
+	MaxInFlight int `yaml:"max_in_flight" toml:"max_in_flight"`
+}
+
+// RESPConfig configures the Redis protocol listener.
+type RESPConfig struct {
+	// ListenAddr is empty to disable the listener.
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+}
+
+// MemcacheConfig configures the memcached text protocol listener.
+type MemcacheConfig struct {
+	// ListenAddr is empty to disable the listener.
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+}
+
+// GRPCConfig configures the gRPC listener.
+type GRPCConfig struct {
+	// ListenAddr is empty to disable the listener.
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+}
+
+type StorageConfig struct {
+	Backend         string           `yaml:"backend" toml:"backend"`
+	DSN             string           `yaml:"dsn" toml:"dsn"`
+	MaxOpenConns    int              `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int              `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration    `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration    `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	Encryption      EncryptionConfig `yaml:"encryption" toml:"encryption"`
+	Breaker         BreakerConfig    `yaml:"breaker" toml:"breaker"`
+	Retry           RetryConfig      `yaml:"retry" toml:"retry"`
+}
+
+// RetryConfig bounds retries of transient database errors such as
+// deadlocks. Each backoff is a random duration up to BaseDelay doubled per
+// attempt, capped at MaxDelay. MaxAttempts of 1 disables retries.
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay" toml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay" toml:"max_delay"`
+}
+
+// BreakerConfig controls the circuit breaker around the store. After
+// FailureThreshold consecutive errors, storage calls fail fast for
+// OpenTimeout before a single probe is let through.
+type BreakerConfig struct {
+	Enabled          bool          `yaml:"enabled" toml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold" toml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout" toml:"open_timeout"`
+	// StaleReads marks cache hits /get serves while the breaker is open
+	// with a Warning header, as the store cannot confirm they are current.
+	StaleReads bool `yaml:"stale_reads" toml:"stale_reads"`
+}
+
+// EncryptionConfig enables encryption at rest when KeyFile is set. The key
+// file holds one "<id> <base64 32-byte key>" line per key; ActiveKey, or
+// the last key in the file, encrypts new values.
+type EncryptionConfig struct {
+	KeyFile   string `yaml:"key_file" toml:"key_file"`
+	ActiveKey string `yaml:"active_key" toml:"active_key"`
+	// Reencrypt starts a background job on start-up that re-encrypts
+	// values not sealed with the active key.
+	Reencrypt bool `yaml:"reencrypt" toml:"reencrypt"`
+}
+
+type CacheConfig struct {
+	Size         int    `yaml:"size" toml:"size"`
+	Policy       string `yaml:"policy" toml:"policy"`
+	Snapshot     string `yaml:"snapshot" toml:"snapshot"`
+	SnapshotKeys int    `yaml:"snapshot_keys" toml:"snapshot_keys"`
+	WarmupQuery  string `yaml:"warmup_query" toml:"warmup_query"`
+}
+
+// Default returns the settings the server used before it was configurable.
+func Default() Config {
+	return Config{
+		ListenAddr: ":8080",
+		Server: ServerConfig{
+			GetKey:          "auto",
+			ShutdownTimeout: 10 * time.Second,
+			MaxBodyBytes:    1 << 20,
+			MaxKeyBytes:     20,
+			MaxValueBytes:   64 << 10,
+
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			RequestTimeout:    10 * time.Second,
+
+			HTTP2:                     true,
+			HTTP2MaxConcurrentStreams: 250,
+		},
+		TLS: TLSConfig{
+			ClientAuth:     "none",
+			ReloadInterval: 30 * time.Second,
+		},
+		Admin: AdminConfig{
+			ListenAddr: "127.0.0.1:6060",
+		},
+		Binary: BinaryConfig{
+			MaxFrameBytes: 4 << 20,
+			MaxInFlight:   128,
+		},
+		Storage: StorageConfig{
+			Backend:      "mysql",
+			DSN:          "root:password@tcp(127.0.0.1:3306)/decsdb",
+			MaxIdleConns: 2,
+			Encryption: EncryptionConfig{
+				Reencrypt: true,
+			},
+			Breaker: BreakerConfig{
+				Enabled:          true,
+				FailureThreshold: 5,
+				OpenTimeout:      10 * time.Second,
+				StaleReads:       true,
+			},
+			Retry: RetryConfig{
+				MaxAttempts: 3,
+				BaseDelay:   10 * time.Millisecond,
+				MaxDelay:    200 * time.Millisecond,
+			},
+		},
+		Cache: CacheConfig{
+			Size:         10,
+			Policy:       "lru",
+			SnapshotKeys: 10,
+		},
+		Inject: inject.State{
+			Enabled: true,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			SampleRate: 1,
+		},
+		ACL: ACLConfig{
+			ReloadInterval: 5 * time.Second,
+		},
+		Audit: AuditConfig{
+			MaxSize:    100 << 20,
+			MaxBackups: 10,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "kv-server",
+			SampleRate:  1,
+		},
+	}
+}
+
+// setting ties one field to its file key, flag and environment variable.
+// The environment variable is EnvPrefix followed by the upper-cased key
+// with dots replaced by underscores.
+type setting struct {
+	key   string
+	flag  string
+	usage string
+	ptr   func(*Config) any
+}
+
+var settings = []setting{
+	{"listen_addr", "listen", "address to listen on", func(c *Config) any { return &c.ListenAddr }},
+	{"server.get_key", "get-key", "where /get reads its key from (auto, body, query)", func(c *Config) any { return &c.Server.GetKey }},
+	{"server.shutdown_timeout", "shutdown-timeout", "how long to drain in-flight requests on shutdown", func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{"server.max_body_bytes", "max-body-bytes", "largest request body accepted", func(c *Config) any { return &c.Server.MaxBodyBytes }},
+	{"server.max_key_bytes", "max-key-bytes", "longest key accepted, in decimal digits", func(c *Config) any { return &c.Server.MaxKeyBytes }},
+	{"server.max_value_bytes", "max-value-bytes", "largest value accepted", func(c *Config) any { return &c.Server.MaxValueBytes }},
+	{"server.read_header_timeout", "read-header-timeout", "time allowed to read request headers (0 = none)", func(c *Config) any { return &c.Server.ReadHeaderTimeout }},
+	{"server.read_timeout", "read-timeout", "time allowed to read a whole request (0 = none)", func(c *Config) any { return &c.Server.ReadTimeout }},
+	{"server.write_timeout", "write-timeout", "time allowed to write a response (0 = none)", func(c *Config) any { return &c.Server.WriteTimeout }},
+	{"server.idle_timeout", "idle-timeout", "how long idle keep-alive connections stay open (0 = none)", func(c *Config) any { return &c.Server.IdleTimeout }},
+	{"server.http2", "http2", "serve HTTP/2 over TLS and h2c on plaintext", func(c *Config) any { return &c.Server.HTTP2 }},
+	{"server.http2_max_concurrent_streams", "http2-max-streams", "most requests in flight on one HTTP/2 connection", func(c *Config) any { return &c.Server.HTTP2MaxConcurrentStreams }},
+	{"server.request_timeout", "request-timeout", "default deadline for handling a request", func(c *Config) any { return &c.Server.RequestTimeout }},
+	{"server.endpoint_timeouts", "endpoint-timeouts", `per-path request deadlines as JSON, e.g. {"/get":"500ms"}`, func(c *Config) any { return &c.Server.EndpointTimeouts }},
+	{"tls.cert_file", "tls-cert", "PEM certificate to serve HTTPS with", func(c *Config) any { return &c.TLS.CertFile }},
+	{"tls.key_file", "tls-key", "PEM private key for tls.cert_file", func(c *Config) any { return &c.TLS.KeyFile }},
+	{"tls.client_ca_file", "tls-client-ca", "PEM CA bundle to verify client certificates", func(c *Config) any { return &c.TLS.ClientCAFile }},
+	{"tls.client_auth", "tls-client-auth", "client certificate mode (none, request, require)", func(c *Config) any { return &c.TLS.ClientAuth }},
+	{"tls.reload_interval", "tls-reload-interval", "how often to check the certificate files for rotation", func(c *Config) any { return &c.TLS.ReloadInterval }},
+	{"admin.listen_addr", "admin-listen", "address for the admin and debug endpoints (empty disables)", func(c *Config) any { return &c.Admin.ListenAddr }},
+	{"binary.listen_addr", "binary-listen", "address for the binary protocol (empty disables)", func(c *Config) any { return &c.Binary.ListenAddr }},
+	{"binary.max_frame_bytes", "binary-max-frame-bytes", "largest binary protocol request frame accepted", func(c *Config) any { return &c.Binary.MaxFrameBytes }},
+	{"binary.max_in_flight", "binary-max-in-flight", "concurrent requests per binary protocol connection", func(c *Config) any { return &c.Binary.MaxInFlight }},
+	{"resp.listen_addr", "resp-listen", "address for the Redis (RESP) protocol (empty disables)", func(c *Config) any { return &c.RESP.ListenAddr }},
+	{"memcache.listen_addr", "memcache-listen", "address for the memcached text protocol (empty disables)", func(c *Config) any { return &c.Memcache.ListenAddr }},
+	{"grpc.listen_addr", "grpc-listen", "address for the gRPC API (empty disables)", func(c *Config) any { return &c.GRPC.ListenAddr }},
+	{"storage.backend", "storage", "storage backend (mysql, memory)", func(c *Config) any { return &c.Storage.Backend }},
+	{"storage.dsn", "dsn", "storage data source name", func(c *Config) any { return &c.Storage.DSN }},
+	{"storage.max_open_conns", "db-max-open-conns", "maximum open database connections (0 = unlimited)", func(c *Config) any { return &c.Storage.MaxOpenConns }},
+	{"storage.max_idle_conns", "db-max-idle-conns", "maximum idle database connections", func(c *Config) any { return &c.Storage.MaxIdleConns }},
+	{"storage.conn_max_lifetime", "db-conn-max-lifetime", "maximum database connection lifetime (0 = forever)", func(c *Config) any { return &c.Storage.ConnMaxLifetime }},
+	{"storage.conn_max_idle_time", "db-conn-max-idle-time", "maximum database connection idle time (0 = forever)", func(c *Config) any { return &c.Storage.ConnMaxIdleTime }},
+	{"storage.encryption.key_file", "encryption-key-file", "key file for encrypting values at rest (empty stores plaintext)", func(c *Config) any { return &c.Storage.Encryption.KeyFile }},
+	{"storage.encryption.active_key", "encryption-active-key", "key id to encrypt new values with (default: last in the key file)", func(c *Config) any { return &c.Storage.Encryption.ActiveKey }},
+	{"storage.encryption.reencrypt", "reencrypt", "re-encrypt values under old keys in the background on start", func(c *Config) any { return &c.Storage.Encryption.Reencrypt }},
+	{"storage.breaker.enabled", "breaker", "fail storage calls fast after repeated errors", func(c *Config) any { return &c.Storage.Breaker.Enabled }},
+	{"storage.breaker.failure_threshold", "breaker-failures", "consecutive storage errors that open the circuit breaker", func(c *Config) any { return &c.Storage.Breaker.FailureThreshold }},
+	{"storage.breaker.open_timeout", "breaker-open-timeout", "how long the circuit breaker stays open before probing", func(c *Config) any { return &c.Storage.Breaker.OpenTimeout }},
+	{"storage.breaker.stale_reads", "stale-reads", "mark cached values served while the circuit breaker is open as stale", func(c *Config) any { return &c.Storage.Breaker.StaleReads }},
+	{"storage.retry.max_attempts", "retry-attempts", "attempts per storage call for transient database errors (1 = no retries)", func(c *Config) any { return &c.Storage.Retry.MaxAttempts }},
+	{"storage.retry.base_delay", "retry-base-delay", "backoff before the first retry", func(c *Config) any { return &c.Storage.Retry.BaseDelay }},
+	{"storage.retry.max_delay", "retry-max-delay", "longest backoff between retries", func(c *Config) any { return &c.Storage.Retry.MaxDelay }},
+	{"cache.size", "cache-size", "number of entries held in the cache", func(c *Config) any { return &c.Cache.Size }},
+	{"cache.policy", "cache-policy", "cache policy (lru, none)", func(c *Config) any { return &c.Cache.Policy }},
+	{"cache.snapshot", "snapshot", "file to save the hottest cache keys to on shutdown and preload from on start", func(c *Config) any { return &c.Cache.Snapshot }},
+	{"cache.snapshot_keys", "snapshot-keys", "number of hottest cache keys to save in the snapshot", func(c *Config) any { return &c.Cache.SnapshotKeys }},
+	{"cache.warmup_query", "warmup-query", "SQL query returning (id, value) rows to preload into the cache on start", func(c *Config) any { return &c.Cache.WarmupQuery }},
+	{"inject.enabled", "inject", "apply synthetic CPU work and latency", func(c *Config) any { return &c.Inject.Enabled }},
+	{"inject.endpoints", "inject-endpoints", `per-path synthetic cost as JSON, e.g. {"/get":{"cpu_work":30000,"latency":"2ms"}}`, func(c *Config) any { return &c.Inject.Endpoints }},
+	{"auth.enabled", "auth", "require credentials on /get, /put and /delete", func(c *Config) any { return &c.Auth.Enabled }},
+	{"auth.api_keys", "api-keys", `static API keys as JSON, e.g. [{"name":"team-a","key":"...","scopes":["read"]}]`, func(c *Config) any { return &c.Auth.APIKeys }},
+	{"auth.jwt.secret", "jwt-secret", "HMAC secret for bearer tokens", func(c *Config) any { return &c.Auth.JWT.Secret }},
+	{"auth.jwt.secret_file", "jwt-secret-file", "file holding the HMAC secret for bearer tokens", func(c *Config) any { return &c.Auth.JWT.SecretFile }},
+	{"auth.jwt.issuer", "jwt-issuer", "required iss claim of bearer tokens", func(c *Config) any { return &c.Auth.JWT.Issuer }},
+	{"auth.jwt.audience", "jwt-audience", "required aud claim of bearer tokens", func(c *Config) any { return &c.Auth.JWT.Audience }},
+	{"acl.file", "acl-file", "YAML ACL policy file (empty allows everything)", func(c *Config) any { return &c.ACL.File }},
+	{"acl.reload_interval", "acl-reload-interval", "how often to check the ACL file for changes", func(c *Config) any { return &c.ACL.ReloadInterval }},
+	{"rate_limit.enabled", "rate-limit", "throttle /get, /put and /delete per client and endpoint", func(c *Config) any { return &c.RateLimit.Enabled }},
+	{"rate_limit.default.rate", "rate-limit-rate", "default requests per second per client and endpoint (0 = unlimited)", func(c *Config) any { return &c.RateLimit.Default.Rate }},
+	{"rate_limit.default.burst", "rate-limit-burst", "default burst size per client and endpoint", func(c *Config) any { return &c.RateLimit.Default.Burst }},
+	{"rate_limit.endpoints", "rate-limit-endpoints", `per-path limits as JSON, e.g. {"/put":{"rate":100,"burst":20}}`, func(c *Config) any { return &c.RateLimit.Endpoints }},
+	{"rate_limit.clients", "rate-limit-clients", `per-client limits as JSON, keyed by principal or IP, e.g. {"team-a":{"rate":500,"burst":50}}`, func(c *Config) any { return &c.RateLimit.Clients }},
+	{"quotas", "quotas", `per-namespace storage quotas as JSON, e.g. [{"namespace":"team-a","keys":["1*"],"max_keys":1000}]`, func(c *Config) any { return &c.Quotas }},
+	{"audit.file", "audit-file", "JSON lines file recording every put and delete (empty disables)", func(c *Config) any { return &c.Audit.File }},
+	{"audit.max_size", "audit-max-size", "size in bytes at which the audit log is rotated", func(c *Config) any { return &c.Audit.MaxSize }},
+	{"audit.max_backups", "audit-max-backups", "number of rotated audit log files to keep", func(c *Config) any { return &c.Audit.MaxBackups }},
+	{"log.level", "log-level", "minimum log level (debug, info, warn, error)", func(c *Config) any { return &c.Log.Level }},
+	{"log.sample_rate", "log-sample-rate", "fraction of non-5xx access log lines to write", func(c *Config) any { return &c.Log.SampleRate }},
+	{"tracing.exporter", "trace-exporter", "span exporter (none, stdout, file, otlp)", func(c *Config) any { return &c.Tracing.Exporter }},
+	{"tracing.file", "trace-file", "file the file exporter appends spans to", func(c *Config) any { return &c.Tracing.File }},
+	{"tracing.endpoint", "trace-endpoint", "OTLP/HTTP traces URL for the otlp exporter", func(c *Config) any { return &c.Tracing.Endpoint }},
+	{"tracing.service_name", "trace-service-name", "service.name reported to the OTLP collector", func(c *Config) any { return &c.Tracing.ServiceName }},
+	{"tracing.sample_rate", "trace-sample-rate", "fraction of new traces to record", func(c *Config) any { return &c.Tracing.SampleRate }},
+}
+
+func (s setting) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+// flagValue records a flag's raw value so it can be applied after the file
+// and environment have been read.
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string { return f.value }
+
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+func (f *flagValue) Set(s string) error {
+	f.value, f.set = s, true
+	return nil
+}
+
+// Load resolves the configuration from def, the config file named by
+// -config or KV_CONFIG, the environment and args, which should not include
+// the program name.
+func Load(def Config, args []string) (*Config, error) {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML or TOML config file")
+
+	values := make([]*flagValue, len(settings))
+	for i, s := range settings {
+		_, isBool := s.ptr(&def).(*bool)
+		values[i] = &flagValue{value: format(s.ptr(&def)), isBool: isBool}
+		fs.Var(values[i], s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := def
+	if *configPath != "" {
+		if err := loadFile(&cfg, *configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := parseInto(s.ptr(&cfg), v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s: %w", v, s.env(), err)
+			}
+		}
+	}
+
+	for i, s := range settings {
+		if values[i].set {
+			if err := parseInto(s.ptr(&cfg), values[i].value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for -%s: %w", values[i].value, s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config %s: unknown setting %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config %s: unsupported format, want .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+func format(ptr any) string {
+	switch p := ptr.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *time.Duration:
+		return p.String()
+	}
+
+	// Structured settings are given as JSON on the command line and in
+	// the environment.
+	b, err := json.Marshal(ptr)
+	if err != nil {
+		panic(fmt.Sprintf("config: unsupported setting type %T", ptr))
+	}
+	switch string(b) {
+	case "null", "{}", "[]":
+		return ""
+	}
+	return string(b)
+}
+
+func parseInto(ptr any, s string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*p = f
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*p = d
+	default:
+		v := reflect.New(reflect.TypeOf(ptr).Elem())
+		if err := json.Unmarshal([]byte(s), v.Interface()); err != nil {
+			return err
+		}
+		reflect.ValueOf(ptr).Elem().Set(v.Elem())
+	}
+	return nil
+}
+
+// Validate reports the first setting that is missing or out of range.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("listen_addr %q: %w", c.ListenAddr, err)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case "none":
+	case "request", "require":
+		if !c.TLS.Enabled() || c.TLS.ClientCAFile == "" {
+			return fmt.Errorf("tls.client_auth %q needs tls.cert_file and tls.client_ca_file", c.TLS.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("tls.client_auth %q: want none, request or require", c.TLS.ClientAuth)
+	}
+	if c.TLS.ReloadInterval <= 0 {
+		return errors.New("tls.reload_interval must be positive")
+	}
+
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+	if c.Binary.MaxFrameBytes <= 0 || c.Binary.MaxInFlight <= 0 {
+		return errors.New("binary.max_frame_bytes and binary.max_in_flight must be positive")
+	}
+
+	switch c.Server.GetKey {
+	case "auto", "body", "query":
+	default:
+		return fmt.Errorf("server.get_key %q: want auto, body or query", c.Server.GetKey)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return errors.New("server.shutdown_timeout must be positive")
+	}
+	if c.Server.ReadHeaderTimeout < 0 || c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		return errors.New("server read, write and idle timeouts must not be negative")
+	}
+	if c.Server.HTTP2MaxConcurrentStreams <= 0 {
+		return errors.New("server.http2_max_concurrent_streams must be positive")
+	}
+	if c.Server.RequestTimeout <= 0 {
+		return errors.New("server.request_timeout must be positive")
+	}
+	for path, d := range c.Server.EndpointTimeouts {
+		if d <= 0 {
+			return fmt.Errorf("server.endpoint_timeouts %s must be positive", path)
+		}
+	}
+	if c.Server.MaxBodyBytes <= 0 || c.Server.MaxKeyBytes <= 0 || c.Server.MaxValueBytes <= 0 {
+		return errors.New("server.max_body_bytes, max_key_bytes and max_value_bytes must be positive")
+	}
+
+	switch c.Storage.Backend {
+	case "mysql", "memory":
+	default:
+		return fmt.Errorf("storage.backend %q: unsupported backend", c.Storage.Backend)
+	}
+	if c.Storage.Backend == "mysql" && c.Storage.DSN == "" {
+		return errors.New("storage.dsn must be set")
+	}
+	if c.Storage.Encryption.ActiveKey != "" && c.Storage.Encryption.KeyFile == "" {
+		return errors.New("storage.encryption.active_key needs storage.encryption.key_file")
+	}
+	if c.Storage.Breaker.FailureThreshold < 1 {
+		return errors.New("storage.breaker.failure_threshold must be at least 1")
+	}
+	if c.Storage.Breaker.OpenTimeout <= 0 {
+		return errors.New("storage.breaker.open_timeout must be positive")
+	}
+	if c.Storage.Retry.MaxAttempts < 1 {
+		return errors.New("storage.retry.max_attempts must be at least 1")
+	}
+	if c.Storage.Retry.BaseDelay <= 0 || c.Storage.Retry.MaxDelay < c.Storage.Retry.BaseDelay {
+		return errors.New("storage.retry.base_delay must be positive and at most max_delay")
+	}
+	if c.Storage.MaxOpenConns < 0 {
+		return errors.New("storage.max_open_conns must not be negative")
+	}
+	if c.Storage.MaxIdleConns < 0 {
+		return errors.New("storage.max_idle_conns must not be negative")
+	}
+	if c.Storage.ConnMaxLifetime < 0 {
+		return errors.New("storage.conn_max_lifetime must not be negative")
+	}
+	if c.Storage.ConnMaxIdleTime < 0 {
+		return errors.New("storage.conn_max_idle_time must not be negative")
+	}
+
+	switch c.Cache.Policy {
+	case "lru":
+		if c.Cache.Size <= 0 {
+			return errors.New("cache.size must be positive with the lru policy")
+		}
+	case "none":
+	default:
+		return fmt.Errorf("cache.policy %q: want lru or none", c.Cache.Policy)
+	}
+	if c.Cache.SnapshotKeys < 0 {
+		return errors.New("cache.snapshot_keys must not be negative")
+	}
+
+	if err := c.Inject.Validate(); err != nil {
+		return fmt.Errorf("inject.endpoints %w", err)
+	}
+
+	if err := c.RateLimit.Validate(); err != nil {
+		return fmt.Errorf("rate_limit %w", err)
+	}
+	for i, q := range c.Quotas {
+		if q.Namespace == "" {
+			return fmt.Errorf("quotas[%d]: namespace must be set", i)
+		}
+		if q.MaxKeys < 0 || q.MaxBytes < 0 {
+			return fmt.Errorf("quotas[%d]: max_keys and max_bytes must not be negative", i)
+		}
+		for _, pattern := range q.Keys {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("quotas[%d]: key pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+
+	if c.Audit.MaxSize <= 0 {
+		return errors.New("audit.max_size must be positive")
+	}
+	if c.Audit.MaxBackups < 0 {
+		return errors.New("audit.max_backups must not be negative")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return fmt.Errorf("log.level %q: want debug, info, warn or error", c.Log.Level)
+	}
+	if c.Log.SampleRate < 0 || c.Log.SampleRate > 1 {
+		return errors.New("log.sample_rate must be between 0 and 1")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			return errors.New("tracing.file must be set for the file exporter")
+		}
+	case "otlp":
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("tracing.endpoint %q: want an absolute URL", c.Tracing.Endpoint)
+		}
+	default:
+		return fmt.Errorf("tracing.exporter %q: want none, stdout, file or otlp", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+		return errors.New("tracing.sample_rate must be between 0 and 1")
+	}
+
+	if c.ACL.ReloadInterval <= 0 {
+		return errors.New("acl.reload_interval must be positive")
+	}
+
+	return c.Auth.validate()
+}
+
+// validateListeners checks the optional listener addresses and that no two
+// listeners share one.
+func (c *Config) validateListeners() error {
+	listeners := []struct{ key, addr string }{
+		{"listen_addr", c.ListenAddr},
+		{"admin.listen_addr", c.Admin.ListenAddr},
+		{"binary.listen_addr", c.Binary.ListenAddr},
+		{"resp.listen_addr", c.RESP.ListenAddr},
+		{"memcache.listen_addr", c.Memcache.ListenAddr},
+		{"grpc.listen_addr", c.GRPC.ListenAddr},
+	}
+	for i, l := range listeners {
+		if l.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(l.addr); err != nil {
+			return fmt.Errorf("%s %q: %w", l.key, l.addr, err)
+		}
+		for _, other := range listeners[:i] {
+			if l.addr == other.addr {
+				return fmt.Errorf("%s must differ from %s", l.key, other.key)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *AuthConfig) validate() error {
+	names := map[string]bool{}
+	for i, k := range c.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return fmt.Errorf("auth.api_keys[%d]: name and key must be set", i)
+		}
+		if names[k.Name] {
+			return fmt.Errorf("auth.api_keys[%d]: duplicate name %q", i, k.Name)
+		}
+		names[k.Name] = true
+		for _, scope := range k.Scopes {
+			if scope != "read" && scope != "write" && scope != "admin" {
+				return fmt.Errorf("auth.api_keys[%d]: scope %q: want read, write or admin", i, scope)
+			}
+		}
+	}
+
+	if c.JWT.Secret != "" && c.JWT.SecretFile != "" {
+		return errors.New("auth.jwt: set only one of secret and secret_file")
+	}
+	if c.Enabled && len(c.APIKeys) == 0 && c.JWT.Secret == "" && c.JWT.SecretFile == "" {
+		return errors.New("auth.enabled needs api_keys or a jwt secret")
+	}
+	return nil
+}
+
+// CacheCapacity is the number of entries the cache should hold; zero
+// disables caching.
+func (c *Config) CacheCapacity() int {
+	if c.Cache.Policy == "none" {
+		return 0
+	}
+	return c.Cache.Size
+}
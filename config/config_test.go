@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "kv.yaml", `
+listen_addr: ":9001"
+cache:
+  size: 20
+  policy: none
+server:
+  request_timeout: 3s
+`)
+	t.Setenv("KV_CONFIG", path)
+	t.Setenv("KV_CACHE_SIZE", "30")
+	t.Setenv("KV_LISTEN_ADDR", ":9002")
+
+	cfg, err := Load(Default(), []string{"-listen", ":9003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		key      string
+		got, want any
+	}{
+		// A flag beats the environment, which beats the file.
+		{"listen_addr", cfg.ListenAddr, ":9003"},
+		{"cache.size", cfg.Cache.Size, 30},
+		{"cache.policy", cfg.Cache.Policy, "none"},
+		{"server.request_timeout", cfg.Server.RequestTimeout, 3 * time.Second},
+		// Settings named nowhere keep their default.
+		{"server.shutdown_timeout", cfg.Server.ShutdownTimeout, Default().Server.ShutdownTimeout},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.key, tc.got, tc.want)
+		}
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	for _, tc := range []struct {
+		name, content string
+	}{
+		{"kv.yaml", "cache:\n  size: 42\nserver:\n  endpoint_timeouts:\n    /get: 250ms\n"},
+		{"kv.toml", "[cache]\nsize = 42\n[server.endpoint_timeouts]\n\"/get\" = \"250ms\"\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := Load(Default(), []string{"-config", writeFile(t, tc.name, tc.content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Cache.Size != 42 || cfg.Server.EndpointTimeouts["/get"] != 250*time.Millisecond {
+				t.Errorf("got cache.size %d and /get timeout %v", cfg.Cache.Size, cfg.Server.EndpointTimeouts["/get"])
+			}
+		})
+	}
+
+	t.Setenv("KV_SERVER_ENDPOINT_TIMEOUTS", `{"/put":"1s"}`)
+	cfg, err := Load(Default(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Server.EndpointTimeouts["/put"]; got != time.Second {
+		t.Errorf("JSON setting from the environment: /put timeout %v, want 1s", got)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, want string
+	}{
+		{"kv.yaml", "cache:\n  sise: 10\n", "field sise not found"},
+		{"kv.toml", "[cache]\nsise = 10\n", `unknown setting "cache.sise"`},
+		{"kv.json", "{}", "unsupported format"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(Default(), []string{"-config", writeFile(t, tc.name, tc.content)})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := Load(Default(), []string{"-no-such-flag"}); err == nil {
+		t.Error("unknown flag accepted")
+	}
+	t.Setenv("KV_CACHE_SIZE", "lots")
+	if _, err := Load(Default(), nil); err == nil || !strings.Contains(err.Error(), "KV_CACHE_SIZE") {
+		t.Errorf("bad environment value: got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"bad listen address", func(c *Config) { c.ListenAddr = "8080" }, "listen_addr"},
+		{"shared listener", func(c *Config) { c.Admin.ListenAddr = c.ListenAddr }, "admin.listen_addr must differ from listen_addr"},
+		{"half a key pair", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "must be set together"},
+		{"client auth without CA", func(c *Config) { c.TLS.ClientAuth = "require" }, "tls.client_auth"},
+		{"unknown backend", func(c *Config) { c.Storage.Backend = "redis" }, "storage.backend"},
+		{"mysql without DSN", func(c *Config) { c.Storage.Backend, c.Storage.DSN = "mysql", "" }, "storage.dsn"},
+		{"zero cache", func(c *Config) { c.Cache.Size = 0 }, "cache.size"},
+		{"unknown policy", func(c *Config) { c.Cache.Policy = "lfu" }, "cache.policy"},
+		{"zero endpoint timeout", func(c *Config) { c.Server.EndpointTimeouts = Durations{"/get": 0} }, "server.endpoint_timeouts /get"},
+		{"retry delays", func(c *Config) { c.Storage.Retry.MaxDelay = c.Storage.Retry.BaseDelay / 2 }, "storage.retry.base_delay"},
+		{"sample rate", func(c *Config) { c.Log.SampleRate = 1.5 }, "log.sample_rate"},
+		{"file exporter", func(c *Config) { c.Tracing.Exporter = "file" }, "tracing.file"},
+		{"quota pattern", func(c *Config) { c.Quotas = []Quota{{Namespace: "a", Keys: []string{"["}}} }, "quotas[0]"},
+		{"unknown scope", func(c *Config) {
+			c.Auth.APIKeys = []APIKey{{Name: "a", Key: "k", Scopes: []string{"root"}}}
+		}, `scope "root"`},
+		{"auth without credentials", func(c *Config) { c.Auth.Enabled = true }, "auth.enabled"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			tc.modify(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+
+	cfg := Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults invalid: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package envelope encrypts values with AES-256-GCM under a fresh data key
+// per value, itself encrypted ("wrapped") with a long-lived key from a key
+// ring. Rotating the ring key only requires re-wrapping data keys.
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Prefix marks a sealed value. Values without it are treated as
+// plaintext written before encryption was enabled.
+const Prefix = "enc:v1:"
+
+const keySize = 32
+
+var (
+	ErrUnknownKey = errors.New("envelope: unknown key id")
+	ErrMalformed  = errors.New("envelope: malformed sealed value")
+)
+
+// Keyring holds the key-encryption keys by ID. New values are sealed with
+// the active key; any key in the ring can open.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// LoadKeyring reads a key file with one "<id> <base64 32-byte key>" line
+// per key; blank lines and lines starting with # are ignored. active
+// selects the key for new values and defaults to the last one in the
+// file.
+func LoadKeyring(path, active string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := &Keyring{keys: make(map[string]cipher.AEAD)}
+	last := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, found := strings.Cut(line, " ")
+		if !found || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("%s:%d: want \"<id> <base64 key>\"", path, n)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("%s:%d: key %s must be %d base64-encoded bytes", path, n, id, keySize)
+		}
+		if _, dup := kr.keys[id]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %s", path, n, id)
+		}
+		if kr.keys[id], err = newGCM(key); err != nil {
+			return nil, err
+		}
+		last = id
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if active == "" {
+		active = last
+	}
+	if _, found := kr.keys[active]; !found {
+		return nil, fmt.Errorf("%s: active key %q: %w", path, active, ErrUnknownKey)
+	}
+	kr.active = active
+	return kr, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Active returns the ID of the key new values are sealed with.
+func (kr *Keyring) Active() string {
+	return kr.active
+}
+
+// Seal encrypts plaintext stored under record. The record key is bound in
+// as additional data so a sealed value cannot be copied to another key.
+//
+// The result is "enc:v1:<key id>:<wrapped data key>:<ciphertext>", each
+// binary part base64-encoded with its nonce prepended.
+func (kr *Keyring) Seal(record int, plaintext string) (string, error) {
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	ad := []byte(strconv.Itoa(record))
+	ciphertext, err := seal(gcm, []byte(plaintext), ad)
+	if err != nil {
+		return "", err
+	}
+	return kr.wrap(kr.active, dek, ciphertext, ad)
+}
+
+func (kr *Keyring) wrap(id string, dek, ciphertext, ad []byte) (string, error) {
+	wrapped, err := seal(kr.keys[id], dek, append([]byte(id+":"), ad...))
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return Prefix + id + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a value sealed for record. Values without Prefix are
+// returned unchanged.
+func (kr *Keyring) Open(record int, value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+	_, dek, ciphertext, ad, err := kr.unwrap(record, value)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(gcm, ciphertext, ad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (kr *Keyring) unwrap(record int, value string) (id string, dek, ciphertext, ad []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, nil, ErrMalformed
+	}
+	id = parts[0]
+	kek, found := kr.keys[id]
+	if !found {
+		return "", nil, nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	enc := base64.RawStdEncoding
+	wrapped, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, nil, ErrMalformed
+	}
+	if ciphertext, err = enc.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, nil, ErrMalformed
+	}
+
+	ad = []byte(strconv.Itoa(record))
+	if dek, err = open(kek, wrapped, append([]byte(id+":"), ad...)); err != nil {
+		return "", nil, nil, nil, err
+	}
+	return id, dek, ciphertext, ad, nil
+}
+
+// KeyID returns the ID of the key value was sealed with, or "" for
+// plaintext.
+func KeyID(value string) string {
+	if !strings.HasPrefix(value, Prefix) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	return id
+}
+
+// Rewrap re-seals value under the active key. Only the data key is
+// re-encrypted; plaintext values are sealed from scratch.
+func (kr *Keyring) Rewrap(record int, value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return kr.Seal(record, value)
+	}
+	_, dek, ciphertext, ad, err := kr.unwrap(record, value)
+	if err != nil {
+		return "", err
+	}
+	return kr.wrap(kr.active, dek, ciphertext, ad)
+}
+
+func seal(aead cipher.AEAD, plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, errors.New("envelope: decryption failed")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,176 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeys writes a key file with one key per ID, each filled with a
+// different byte, and returns its path.
+func writeKeys(t *testing.T, ids ...string) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("# test keys\n\n")
+	for i, id := range ids {
+		key := strings.Repeat(string(rune('a'+i)), keySize)
+		b.WriteString(id + " " + base64.StdEncoding.EncodeToString([]byte(key)) + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, path, active string) *Keyring {
+	t.Helper()
+	kr, err := LoadKeyring(path, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	kr := load(t, writeKeys(t, "k1", "k2"), "")
+	if kr.Active() != "k2" {
+		t.Fatalf("active key %q, want the last one, k2", kr.Active())
+	}
+
+	for _, plaintext := range []string{"value", "", "with:colons", strings.Repeat("x", 4096)} {
+		sealed, err := kr.Seal(7, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(sealed, Prefix+"k2:") || strings.Contains(sealed, plaintext) && plaintext != "" {
+			t.Fatalf("sealed %q", sealed)
+		}
+		if KeyID(sealed) != "k2" {
+			t.Errorf("KeyID %q, want k2", KeyID(sealed))
+		}
+		got, err := kr.Open(7, sealed)
+		if err != nil || got != plaintext {
+			t.Errorf("Open: got %q, %v; want %q", got, err, plaintext)
+		}
+
+		// The record key is bound in, so a value copied to another key
+		// does not open.
+		if _, err := kr.Open(8, sealed); err == nil {
+			t.Error("value opened under another record")
+		}
+	}
+
+	a, _ := kr.Seal(1, "same")
+	b, _ := kr.Seal(1, "same")
+	if a == b {
+		t.Error("sealing the same value twice gave the same result")
+	}
+}
+
+func TestOpenRejectsWrongKey(t *testing.T) {
+	sealed, err := load(t, writeKeys(t, "k1"), "").Seal(1, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same ID, different key material.
+	path := filepath.Join(t.TempDir(), "keys")
+	other := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("z", keySize)))
+	if err := os.WriteFile(path, []byte("k1 "+other+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load(t, path, "").Open(1, sealed); err == nil {
+		t.Error("opened with the wrong key")
+	}
+
+	if _, err := load(t, writeKeys(t, "k9"), "").Open(1, sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("missing key: got %v, want ErrUnknownKey", err)
+	}
+
+	kr := load(t, writeKeys(t, "k1"), "")
+	for _, bad := range []string{Prefix + "k1:abc", Prefix + "k1:!!:!!", Prefix + "k1::"} {
+		if _, err := kr.Open(1, bad); err == nil {
+			t.Errorf("Open(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPlaintextPassesThrough(t *testing.T) {
+	kr := load(t, writeKeys(t, "k1"), "")
+	got, err := kr.Open(1, "written before encryption")
+	if err != nil || got != "written before encryption" {
+		t.Fatalf("Open: got %q, %v", got, err)
+	}
+	if KeyID("plain") != "" {
+		t.Errorf("KeyID of plaintext %q", KeyID("plain"))
+	}
+
+	// Rewrapping plaintext seals it.
+	sealed, err := kr.Rewrap(1, "plain")
+	if err != nil || KeyID(sealed) != "k1" {
+		t.Fatalf("Rewrap: got %q, %v", sealed, err)
+	}
+	if got, _ := kr.Open(1, sealed); got != "plain" {
+		t.Errorf("Open after Rewrap: got %q", got)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := writeKeys(t, "old", "new")
+	before := load(t, path, "old")
+	sealed, err := before.Seal(3, "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := load(t, path, "new")
+	// Values under the old key still open.
+	if got, err := after.Open(3, sealed); err != nil || got != "value" {
+		t.Fatalf("Open old value: got %q, %v", got, err)
+	}
+	rewrapped, err := after.Rewrap(3, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyID(rewrapped) != "new" {
+		t.Fatalf("rewrapped under %q, want new", KeyID(rewrapped))
+	}
+	// Only the data key is re-encrypted.
+	if strings.Split(rewrapped, ":")[4] != strings.Split(sealed, ":")[4] {
+		t.Error("Rewrap changed the ciphertext")
+	}
+	if got, err := after.Open(3, rewrapped); err != nil || got != "value" {
+		t.Errorf("Open rewrapped: got %q, %v", got, err)
+	}
+	if _, err := after.Rewrap(4, sealed); err == nil {
+		t.Error("rewrapped a value under another record")
+	}
+}
+
+func TestLoadKeyringRejectsBadFiles(t *testing.T) {
+	good := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", keySize)))
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	for _, tc := range []struct {
+		file, active string
+	}{
+		{"k1\n", ""},
+		{"k:1 " + good + "\n", ""},
+		{"k1 " + short + "\n", ""},
+		{"k1 not-base64\n", ""},
+		{"k1 " + good + "\nk1 " + good + "\n", ""},
+		{"k1 " + good + "\n", "k2"},
+		{"", ""},
+	} {
+		path := filepath.Join(t.TempDir(), "keys")
+		if err := os.WriteFile(path, []byte(tc.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyring(path, tc.active); err == nil {
+			t.Errorf("LoadKeyring accepted %q with active %q", tc.file, tc.active)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package inject adds synthetic CPU work and latency to HTTP requests so
+// capacity-planning experiments can sweep the cost of a request without
+// recompiling the server.
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"decsproject/tracing"
+)
+
+// Settings describe the synthetic cost added to one endpoint.
+type Settings struct {
+	// CPUWork is the number of busy-loop work units run per request.
+	CPUWork int `yaml:"cpu_work" toml:"cpu_work"`
+	// Latency is the fixed delay, or the mean delay for the random
+	// distributions.
+	Latency time.Duration `yaml:"latency" toml:"latency"`
+	// Jitter is the half-width of the uniform distribution or the standard
+	// deviation of the normal distribution.
+	Jitter time.Duration `yaml:"jitter" toml:"jitter"`
+	// Distribution is one of fixed (the default), uniform, normal or
+	// exponential.
+	Distribution string `yaml:"distribution" toml:"distribution"`
+}
+
+// settingsJSON is the admin endpoint's view of Settings, with durations
+// written as strings such as "5ms".
+type settingsJSON struct {
+	CPUWork      int    `json:"cpu_work,omitempty"`
+	Latency      string `json:"latency,omitempty"`
+	Jitter       string `json:"jitter,omitempty"`
+	Distribution string `json:"distribution,omitempty"`
+}
+
+func (s Settings) MarshalJSON() ([]byte, error) {
+	out := settingsJSON{CPUWork: s.CPUWork, Distribution: s.Distribution}
+	if s.Latency != 0 {
+		out.Latency = s.Latency.String()
+	}
+	if s.Jitter != 0 {
+		out.Jitter = s.Jitter.String()
+	}
+	return json.Marshal(out)
+}
+
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	var in settingsJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return err
+	}
+
+	out := Settings{CPUWork: in.CPUWork, Distribution: in.Distribution}
+	var err error
+	if in.Latency != "" {
+		if out.Latency, err = time.ParseDuration(in.Latency); err != nil {
+			return fmt.Errorf("latency: %w", err)
+		}
+	}
+	if in.Jitter != "" {
+		if out.Jitter, err = time.ParseDuration(in.Jitter); err != nil {
+			return fmt.Errorf("jitter: %w", err)
+		}
+	}
+	*s = out
+	return nil
+}
+
+// Validate reports settings that cannot be applied.
+func (s Settings) Validate() error {
+	if s.CPUWork < 0 {
+		return fmt.Errorf("cpu_work must not be negative")
+	}
+	if s.Latency < 0 || s.Jitter < 0 {
+		return fmt.Errorf("latency and jitter must not be negative")
+	}
+	switch s.Distribution {
+	case "", "fixed", "uniform", "normal", "exponential":
+	default:
+		return fmt.Errorf("distribution %q: want fixed, uniform, normal or exponential", s.Distribution)
+	}
+	return nil
+}
+
+// delay samples the latency to add to one request.
+func (s Settings) delay() time.Duration {
+	var d float64
+	switch s.Distribution {
+	case "uniform":
+		d = float64(s.Latency) + (rand.Float64()*2-1)*float64(s.Jitter)
+	case "normal":
+		d = float64(s.Latency) + rand.NormFloat64()*float64(s.Jitter)
+	case "exponential":
+		d = rand.ExpFloat64() * float64(s.Latency)
+	default:
+		d = float64(s.Latency)
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+// State is the complete injection configuration, keyed by request path.
+type State struct {
+	Enabled   bool                `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Endpoints map[string]Settings `json:"endpoints" yaml:"endpoints" toml:"endpoints"`
+}
+
+func (st State) Validate() error {
+	for path, s := range st.Endpoints {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Injector is HTTP middleware applying the current State. It is safe to
+// update the State while requests are in flight.
+type Injector struct {
+	state atomic.Pointer[State]
+}
+
+func New(st State) *Injector {
+	in := &Injector{}
+	in.Set(st)
+	return in
+}
+
+func (in *Injector) State() State {
+	return *in.state.Load()
+}
+
+func (in *Injector) Set(st State) {
+	if st.Endpoints == nil {
+		st.Endpoints = map[string]Settings{}
+	}
+	in.state.Store(&st)
+}
+
+func (in *Injector) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		st := in.state.Load()
+		if s, found := st.Endpoints[req.URL.Path]; st.Enabled && found {
+			_, span := tracing.Start(req.Context(), "inject", tracing.KindInternal)
+			Work(s.CPUWork)
+			d := s.delay()
+			span.SetAttr("inject.cpu_work", s.CPUWork)
+			span.SetAttr("inject.latency_ms", float64(d.Microseconds())/1000)
+			if d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-req.Context().Done():
+					t.Stop()
+				}
+			}
+			span.End()
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// AdminHandler serves the injection state: GET returns it as JSON, PUT
+// replaces it and DELETE disables injection.
+func (in *Injector) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var st State
+			dec := json.NewDecoder(req.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&st); err != nil {
+				http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+				return
+			}
+			if err := st.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			in.Set(st)
+		case http.MethodDelete:
+			st := in.State()
+			st.Enabled = false
+			in.Set(st)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(in.State())
+	})
+}
+
+var sink atomic.Uint64
+
+// Work burns CPU for the given number of work units.
+func Work(units int) {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < units; i++ {
+		h ^= uint64(i)
+		h *= 1099511628211
+	}
+	sink.Store(h)
+}
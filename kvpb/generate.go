@@ -0,0 +1,5 @@
+// Package kvpb holds kv.proto, the gRPC definition of the key value API,
+// and the client and server code generated from it.
+package kvpb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative kvpb/kv.proto
@@ -0,0 +1,176 @@
+// Package memcache reads commands and writes replies in the memcached
+// text protocol, so memcached clients can talk to the server.
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrProtocol is wrapped by errors for input that cannot be framed, such
+// as a data block of the wrong length. The connection cannot be read
+// further after one.
+var ErrProtocol = errors.New("memcache: protocol error")
+
+// ErrTooLarge is returned for a storage command whose data block is over
+// the Reader's limit. The block has been skipped, so the next command can
+// be read.
+var ErrTooLarge = errors.New("memcache: data block too large")
+
+func protocolError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, fmt.Sprintf(format, args...))
+}
+
+// maxLine bounds command lines, which for get carry every key.
+const maxLine = 64 << 10
+
+// Command is one request.
+type Command struct {
+	// Name is lower-cased.
+	Name string
+	Args []string
+	// Data is the data block of a storage command.
+	Data string
+}
+
+// storageCommands are followed by a data block whose length is their
+// fourth argument.
+var storageCommands = map[string]bool{
+	"set": true, "add": true, "replace": true, "append": true, "prepend": true, "cas": true,
+}
+
+// Reader reads commands sent by clients.
+type Reader struct {
+	r *bufio.Reader
+	// max bounds the data block of one storage command.
+	max int
+}
+
+// NewReader returns a Reader whose storage commands may carry at most max
+// bytes of data.
+func NewReader(r io.Reader, max int) *Reader {
+	return &Reader{r: bufio.NewReader(r), max: max}
+}
+
+// Buffered returns how many bytes have been read from the connection but
+// not yet parsed, letting a server hold back flushing replies to
+// pipelined commands.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// ReadCommand returns the next command. It skips empty lines.
+func (r *Reader) ReadCommand() (Command, error) {
+	for {
+		line, err := r.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull || len(line) > maxLine {
+			return Command{}, protocolError("line too long")
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return Command{}, err
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			continue
+		}
+
+		cmd := Command{Name: strings.ToLower(fields[0]), Args: fields[1:]}
+		if !storageCommands[cmd.Name] {
+			return cmd, nil
+		}
+		if len(cmd.Args) < 4 {
+			return Command{}, protocolError("bad command line format")
+		}
+		size, err := strconv.Atoi(cmd.Args[3])
+		if err != nil || size < 0 {
+			return Command{}, protocolError("bad command line format")
+		}
+		if size > r.max {
+			if _, err := r.r.Discard(size + 2); err != nil {
+				return Command{}, io.ErrUnexpectedEOF
+			}
+			return Command{}, ErrTooLarge
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r.r, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Command{}, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return Command{}, protocolError("bad data chunk")
+		}
+		cmd.Data = string(buf[:size])
+		return cmd, nil
+	}
+}
+
+// Writer writes replies.
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Line writes a one-line reply such as STORED, or a number for incr.
+func (w *Writer) Line(s string) {
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// Value writes one item of a get reply, and its CAS token if withCAS.
+// The reply ends with End.
+func (w *Writer) Value(key string, flags uint32, data string, cas uint64, withCAS bool) {
+	w.w.WriteString("VALUE ")
+	w.w.WriteString(key)
+	w.w.WriteByte(' ')
+	w.w.WriteString(strconv.FormatUint(uint64(flags), 10))
+	w.w.WriteByte(' ')
+	w.w.WriteString(strconv.Itoa(len(data)))
+	if withCAS {
+		w.w.WriteByte(' ')
+		w.w.WriteString(strconv.FormatUint(cas, 10))
+	}
+	w.w.WriteString("\r\n")
+	w.w.WriteString(data)
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) End() {
+	w.Line("END")
+}
+
+// Error answers a command the server does not know.
+func (w *Writer) Error() {
+	w.Line("ERROR")
+}
+
+// ClientError reports a malformed or refused request.
+func (w *Writer) ClientError(msg string) {
+	w.Line("CLIENT_ERROR " + oneLine(msg))
+}
+
+// ServerError reports a request the server failed to carry out.
+func (w *Writer) ServerError(msg string) {
+	w.Line("SERVER_ERROR " + oneLine(msg))
+}
+
+// oneLine keeps line breaks in msg from ending the reply early.
+func oneLine(msg string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+}
@@ -0,0 +1,198 @@
+// Package metrics exports the server's Prometheus metrics.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"decsproject/breaker"
+	"decsproject/cache"
+	"decsproject/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kv"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storeDuration   *prometheus.HistogramVec
+
+	protocolRequests        *prometheus.CounterVec
+	protocolRequestDuration *prometheus.HistogramVec
+}
+
+// New creates the request and store metrics and registers the Go runtime and
+// process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by endpoint, method and status code.",
+		}, []string{"endpoint", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency, by endpoint and status code.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"endpoint", "status"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Storage operation latency, by operation and result (ok, not_found, rejected, error).",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"operation", "result"}),
+		protocolRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "protocol_requests_total",
+			Help:      "Requests served over protocols other than HTTP, by protocol, operation and result.",
+		}, []string{"protocol", "operation", "result"}),
+		protocolRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "protocol_request_duration_seconds",
+			Help:      "Latency of requests served over protocols other than HTTP, by protocol and operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"protocol", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.storeDuration,
+		m.protocolRequests,
+		m.protocolRequestDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records one served HTTP request. endpoint should be the
+// matched route rather than the raw path to keep label cardinality bounded.
+func (m *Metrics) ObserveRequest(endpoint, method string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(endpoint, method, code).Inc()
+	m.requestDuration.WithLabelValues(endpoint, code).Observe(d.Seconds())
+}
+
+// ObserveProtocol records one request served over a protocol other than
+// HTTP, such as the binary protocol.
+func (m *Metrics) ObserveProtocol(protocol, op, result string, d time.Duration) {
+	m.protocolRequests.WithLabelValues(protocol, op, result).Inc()
+	m.protocolRequestDuration.WithLabelValues(protocol, op).Observe(d.Seconds())
+}
+
+// ObserveStore records one storage operation; it matches store.ObserveFunc.
+// Calls failed fast by the circuit breaker are counted as "rejected" and
+// lost compare-and-swaps as "conflict".
+func (m *Metrics) ObserveStore(op string, d time.Duration, err error) {
+	result := "ok"
+	if errors.Is(err, store.ErrNotFound) {
+		result = "not_found"
+	} else if errors.Is(err, store.ErrVersionMismatch) {
+		result = "conflict"
+	} else if errors.Is(err, store.ErrUnavailable) {
+		result = "rejected"
+	} else if err != nil {
+		result = "error"
+	}
+	m.storeDuration.WithLabelValues(op, result).Observe(d.Seconds())
+}
+
+// RegisterCache exports the cache's counters and size.
+func (m *Metrics) RegisterCache(c *cache.LRUCache) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Cache lookups that found the key.",
+		}, func() float64 { return float64(c.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Cache lookups that did not find the key.",
+		}, func() float64 { return float64(c.Stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Entries evicted to make room for new ones.",
+		}, func() float64 { return float64(c.Stats().Evictions) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_entries",
+			Help:      "Entries currently cached.",
+		}, func() float64 { return float64(c.Len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_capacity",
+			Help:      "Maximum number of cached entries.",
+		}, func() float64 { return float64(c.Capacity()) }),
+	)
+}
+
+// RegisterBreaker exports the storage circuit breaker's state and
+// counters.
+func (m *Metrics) RegisterBreaker(b *breaker.Breaker) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "store_breaker_state",
+			Help:      "Storage circuit breaker state: 0 closed, 1 half open, 2 open.",
+		}, func() float64 { return float64(b.State()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_breaker_opened_total",
+			Help:      "Times the storage circuit breaker has opened.",
+		}, func() float64 { return float64(b.Stats().Opened) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_breaker_rejected_total",
+			Help:      "Storage calls failed fast by the open circuit breaker.",
+		}, func() float64 { return float64(b.Stats().Rejected) }),
+	)
+}
+
+// RegisterRetries exports the storage retry counters.
+func (m *Metrics) RegisterRetries(r *store.Retrier) {
+	m.registry.MustRegister(retryCollector{r})
+}
+
+var retriesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "store_retries_total"),
+	"Storage calls retried after a transient error, by operation and reason.",
+	[]string{"operation", "reason"}, nil,
+)
+
+type retryCollector struct {
+	r *store.Retrier
+}
+
+func (c retryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- retriesDesc
+}
+
+func (c retryCollector) Collect(ch chan<- prometheus.Metric) {
+	for k, n := range c.r.Retries() {
+		ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(n), k.Operation, k.Reason)
+	}
+}
+
+// RegisterDB exports the connection pool gauges from sql.DBStats.
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"decsproject/breaker"
+	"decsproject/cache"
+	"decsproject/store"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("scrape: got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q, want the text exposition format", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	m := New()
+
+	c := cache.NewLRUCache(2)
+	c.Put("1", "a")
+	c.Get("1")
+	c.Get("2")
+	m.RegisterCache(c)
+
+	b := breaker.New(1, time.Hour)
+	b.Allow()
+	b.Record(false)
+	m.RegisterBreaker(b)
+
+	m.ObserveRequest("/get", "GET", 200, 3*time.Millisecond)
+	m.ObserveRequest("/get", "GET", 200, time.Millisecond)
+	m.ObserveRequest("/put", "PUT", 413, time.Millisecond)
+	m.ObserveProtocol("resp", "get", "ok", time.Millisecond)
+	m.ObserveStore("get", time.Millisecond, nil)
+	m.ObserveStore("get", time.Millisecond, store.ErrNotFound)
+	m.ObserveStore("put", time.Millisecond, store.ErrVersionMismatch)
+	m.ObserveStore("put", time.Millisecond, store.ErrUnavailable)
+	m.ObserveStore("delete", time.Millisecond, errors.New("connection refused"))
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		"# TYPE kv_http_requests_total counter",
+		`kv_http_requests_total{endpoint="/get",method="GET",status="200"} 2`,
+		`kv_http_requests_total{endpoint="/put",method="PUT",status="413"} 1`,
+		"# TYPE kv_http_request_duration_seconds histogram",
+		`kv_http_request_duration_seconds_count{endpoint="/get",status="200"} 2`,
+		`kv_protocol_requests_total{operation="get",protocol="resp",result="ok"} 1`,
+		`kv_store_operation_duration_seconds_count{operation="get",result="ok"} 1`,
+		`kv_store_operation_duration_seconds_count{operation="get",result="not_found"} 1`,
+		`kv_store_operation_duration_seconds_count{operation="put",result="conflict"} 1`,
+		`kv_store_operation_duration_seconds_count{operation="put",result="rejected"} 1`,
+		`kv_store_operation_duration_seconds_count{operation="delete",result="error"} 1`,
+		"kv_cache_hits_total 1",
+		"kv_cache_misses_total 1",
+		"kv_cache_entries 1",
+		"kv_cache_capacity 2",
+		"kv_store_breaker_state 2",
+		"kv_store_breaker_opened_total 1",
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("exposition missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package quota enforces per-namespace limits on how many keys, and how
+// many value bytes, may be stored.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+
+	"decsproject/config"
+)
+
+var ErrExceeded = errors.New("quota exceeded")
+
+// Usage is what a namespace currently stores, alongside its limits.
+type Usage struct {
+	Namespace string `json:"namespace"`
+	Keys      int    `json:"keys"`
+	Bytes     int64  `json:"bytes"`
+	MaxKeys   int    `json:"max_keys,omitempty"`
+	MaxBytes  int64  `json:"max_bytes,omitempty"`
+}
+
+type namespace struct {
+	config.Quota
+	// sizes holds the value length of every key stored in the namespace,
+	// or reserved for a write still in flight.
+	sizes map[int]int
+	bytes int64
+	// pending tracks keys with writes in flight.
+	pending map[int]*pending
+}
+
+// pending is the state of a key with writes in flight. The key's usage is
+// settled once they have all finished: the last one reserved that was
+// stored wins, and if none was the key goes back to how it was before.
+type pending struct {
+	writes  int
+	seq     int
+	existed bool
+	before  int
+	// storedSeq orders the stored writes; zero means none so far.
+	storedSeq  int
+	storedSize int
+}
+
+// Tracker accounts for the keys written through it. A nil Tracker enforces
+// nothing.
+type Tracker struct {
+	mu         sync.Mutex
+	namespaces []*namespace
+}
+
+func New(quotas []config.Quota) *Tracker {
+	if len(quotas) == 0 {
+		return nil
+	}
+	t := &Tracker{}
+	for _, q := range quotas {
+		t.namespaces = append(t.namespaces, &namespace{Quota: q, sizes: make(map[int]int), pending: make(map[int]*pending)})
+	}
+	return t
+}
+
+// lookup returns the first namespace whose patterns match key, or nil.
+func (t *Tracker) lookup(key int) *namespace {
+	s := strconv.Itoa(key)
+	for _, ns := range t.namespaces {
+		for _, pattern := range ns.Keys {
+			if ok, _ := path.Match(pattern, s); ok {
+				return ns
+			}
+		}
+	}
+	return nil
+}
+
+// Seed records a key that already exists in the store, without checking
+// the limits.
+func (t *Tracker) Seed(key int, value string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ns := t.lookup(key); ns != nil {
+		ns.set(key, len(value))
+	}
+}
+
+func (ns *namespace) set(key, size int) {
+	ns.bytes += int64(size - ns.sizes[key])
+	ns.sizes[key] = size
+}
+
+func (ns *namespace) remove(key int) {
+	ns.bytes -= int64(ns.sizes[key])
+	delete(ns.sizes, key)
+}
+
+// Reserve accounts for storing size bytes under key, returning an error
+// wrapping ErrExceeded if that would break its namespace's quota. Once the
+// write has been attempted done must be called, reporting whether the
+// value was stored, so a failed write releases its reservation.
+func (t *Tracker) Reserve(key, size int) (done func(stored bool), err error) {
+	if t == nil {
+		return func(bool) {}, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ns := t.lookup(key)
+	if ns == nil {
+		return func(bool) {}, nil
+	}
+
+	prev, existed := ns.sizes[key]
+	if !existed && ns.MaxKeys > 0 && len(ns.sizes)+1 > ns.MaxKeys {
+		return nil, fmt.Errorf("%w for namespace %s (max_keys %d)", ErrExceeded, ns.Namespace, ns.MaxKeys)
+	}
+	if ns.MaxBytes > 0 && ns.bytes+int64(size-prev) > ns.MaxBytes {
+		return nil, fmt.Errorf("%w for namespace %s (max_bytes %d)", ErrExceeded, ns.Namespace, ns.MaxBytes)
+	}
+
+	p := ns.pending[key]
+	if p == nil {
+		p = &pending{existed: existed, before: prev}
+		ns.pending[key] = p
+	}
+	p.writes++
+	p.seq++
+	seq := p.seq
+	ns.set(key, size)
+
+	return func(stored bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if stored && seq > p.storedSeq {
+			p.storedSeq, p.storedSize = seq, size
+		}
+		if p.writes--; p.writes > 0 {
+			return
+		}
+		delete(ns.pending, key)
+		switch {
+		case p.storedSeq > 0:
+			ns.set(key, p.storedSize)
+		case p.existed:
+			ns.set(key, p.before)
+		default:
+			ns.remove(key)
+		}
+	}, nil
+}
+
+// Remove releases a deleted key.
+func (t *Tracker) Remove(key int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ns := t.lookup(key); ns != nil {
+		ns.remove(key)
+		// Writes still in flight were either stored before the delete or
+		// will recreate the key.
+		if p := ns.pending[key]; p != nil {
+			p.existed, p.before, p.storedSeq = false, 0, 0
+		}
+	}
+}
+
+// Usage reports every namespace's current usage.
+func (t *Tracker) Usage() []Usage {
+	if t == nil {
+		return []Usage{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]Usage, 0, len(t.namespaces))
+	for _, ns := range t.namespaces {
+		out = append(out, Usage{
+			Namespace: ns.Namespace,
+			Keys:      len(ns.sizes),
+			Bytes:     ns.bytes,
+			MaxKeys:   ns.MaxKeys,
+			MaxBytes:  ns.MaxBytes,
+		})
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+
+	"decsproject/config"
+)
+
+func usage(t *testing.T, tr *Tracker) Usage {
+	t.Helper()
+	u := tr.Usage()
+	if len(u) != 1 {
+		t.Fatalf("%d namespaces, want 1", len(u))
+	}
+	return u[0]
+}
+
+func TestReserveEnforcesLimits(t *testing.T) {
+	tr := New([]config.Quota{{Namespace: "a", Keys: []string{"1*"}, MaxKeys: 2, MaxBytes: 10}})
+
+	for _, tc := range []struct {
+		key, size int
+		wantErr   bool
+	}{
+		{10, 4, false},
+		{11, 4, false},
+		// A third key breaks max_keys.
+		{12, 1, true},
+		// Rewriting a key only counts the difference.
+		{10, 6, false},
+		{11, 5, true},
+		// Keys outside every namespace are not limited.
+		{20, 100, false},
+	} {
+		done, err := tr.Reserve(tc.key, tc.size)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("Reserve(%d, %d): %v", tc.key, tc.size, err)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrExceeded) {
+				t.Fatalf("Reserve(%d, %d): %v, want ErrExceeded", tc.key, tc.size, err)
+			}
+			continue
+		}
+		done(true)
+	}
+	if u := usage(t, tr); u.Keys != 2 || u.Bytes != 10 {
+		t.Fatalf("usage %+v, want 2 keys and 10 bytes", u)
+	}
+
+	tr.Remove(10)
+	if u := usage(t, tr); u.Keys != 1 || u.Bytes != 4 {
+		t.Fatalf("usage %+v after Remove, want 1 key and 4 bytes", u)
+	}
+
+	var none *Tracker
+	if done, err := none.Reserve(1, 1<<30); err != nil {
+		t.Fatal(err)
+	} else {
+		done(true)
+	}
+}
+
+func TestFailedWriteReleasesReservation(t *testing.T) {
+	tr := New([]config.Quota{{Namespace: "a", Keys: []string{"*"}, MaxKeys: 10}})
+	tr.Seed(1, "abc")
+
+	// A failed write of a new key frees it, and of an existing key puts
+	// back its old size.
+	done, _ := tr.Reserve(2, 5)
+	done(false)
+	done, _ = tr.Reserve(1, 7)
+	done(false)
+	if u := usage(t, tr); u.Keys != 1 || u.Bytes != 3 {
+		t.Fatalf("usage %+v, want only the seeded key", u)
+	}
+}
+
+func TestOverlappingWritesToNewKey(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		first, then bool // whether each write was stored
+		finishFirst bool // whether the first write finishes first
+		wantKeys    int
+		wantBytes   int64
+	}{
+		{"first fails after second stored", false, true, false, 1, 8},
+		{"first fails before second stored", false, true, true, 1, 8},
+		{"second fails", true, false, true, 1, 4},
+		{"second fails first", true, false, false, 1, 4},
+		{"both stored", true, true, true, 1, 8},
+		{"both fail", false, false, false, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := New([]config.Quota{{Namespace: "a", Keys: []string{"*"}}})
+			done1, _ := tr.Reserve(7, 4)
+			done2, _ := tr.Reserve(7, 8)
+			if tc.finishFirst {
+				done1(tc.first)
+				done2(tc.then)
+			} else {
+				done2(tc.then)
+				done1(tc.first)
+			}
+			if u := usage(t, tr); u.Keys != tc.wantKeys || u.Bytes != tc.wantBytes {
+				t.Fatalf("usage %+v, want %d keys and %d bytes", u, tc.wantKeys, tc.wantBytes)
+			}
+		})
+	}
+
+	// A delete between the writes drops the first one's stored value.
+	tr := New([]config.Quota{{Namespace: "a", Keys: []string{"*"}}})
+	done1, _ := tr.Reserve(7, 4)
+	done2, _ := tr.Reserve(7, 8)
+	done1(true)
+	tr.Remove(7)
+	done2(false)
+	if u := usage(t, tr); u.Keys != 0 || u.Bytes != 0 {
+		t.Fatalf("usage %+v after delete, want none", u)
+	}
+}
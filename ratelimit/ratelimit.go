@@ -0,0 +1,190 @@
+// Package ratelimit throttles clients with token buckets, one per client
+// and endpoint, and lets the limits be changed while the server runs.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second holding at
+// most Burst tokens. A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate" yaml:"rate" toml:"rate"`
+	Burst int     `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+func (l Limit) Validate() error {
+	if l.Rate < 0 {
+		return fmt.Errorf("rate must not be negative")
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1")
+	}
+	return nil
+}
+
+// Settings choose the limit for each client and endpoint: a Clients entry
+// for the client wins over an Endpoints entry for the path, which wins
+// over Default. Every client gets its own bucket per endpoint.
+type Settings struct {
+	Enabled   bool             `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Default   Limit            `json:"default" yaml:"default" toml:"default"`
+	Endpoints map[string]Limit `json:"endpoints" yaml:"endpoints" toml:"endpoints"`
+	Clients   map[string]Limit `json:"clients" yaml:"clients" toml:"clients"`
+}
+
+func (s Settings) Validate() error {
+	if err := s.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for path, l := range s.Endpoints {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("endpoint %s: %w", path, err)
+		}
+	}
+	for client, l := range s.Clients {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("client %s: %w", client, err)
+		}
+	}
+	return nil
+}
+
+func (s Settings) limit(client, endpoint string) Limit {
+	if l, found := s.Clients[client]; found {
+		return l
+	}
+	if l, found := s.Endpoints[endpoint]; found {
+		return l
+	}
+	return s.Default
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// idleTimeout is how long a bucket may go unused before it is dropped; by
+// then it would have refilled anyway.
+const idleTimeout = 10 * time.Minute
+
+type bucketKey struct {
+	client, endpoint string
+}
+
+// Limiter holds the buckets for the current Settings. It is safe for
+// concurrent use.
+type Limiter struct {
+	mu        sync.Mutex
+	settings  Settings
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+func New(s Settings) *Limiter {
+	l := &Limiter{}
+	l.Set(s)
+	return l
+}
+
+func (l *Limiter) Settings() Settings {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.settings
+}
+
+// Set replaces the settings. Buckets start again full.
+func (l *Limiter) Set(s Settings) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings = s
+	l.buckets = make(map[bucketKey]*bucket)
+}
+
+// Allow takes a token from the client's bucket for endpoint. If none is
+// left it returns false and how long until one will be.
+func (l *Limiter) Allow(client, endpoint string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.settings.Enabled {
+		return true, 0
+	}
+	limit := l.settings.limit(client, endpoint)
+	if limit.Rate == 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	k := bucketKey{client, endpoint}
+	b, found := l.buckets[k]
+	if !found {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[k] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops idle buckets at most once per idleTimeout so a stream of
+// distinct clients cannot grow the map without bound.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > idleTimeout {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// AdminHandler serves the settings: GET returns them as JSON, PUT replaces
+// them and DELETE disables rate limiting.
+func (l *Limiter) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var s Settings
+			dec := json.NewDecoder(req.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&s); err != nil {
+				http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+				return
+			}
+			if err := s.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			l.Set(s)
+		case http.MethodDelete:
+			s := l.Settings()
+			s.Enabled = false
+			l.Set(s)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.Settings())
+	})
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllowRefillsTokens(t *testing.T) {
+	l := New(Settings{Enabled: true, Default: Limit{Rate: 20, Burst: 2}})
+
+	for i := range 2 {
+		if ok, _ := l.Allow("a", "/get"); !ok {
+			t.Fatalf("request %d of the burst denied", i+1)
+		}
+	}
+	ok, wait := l.Allow("a", "/get")
+	if ok {
+		t.Fatal("request over the burst allowed")
+	}
+	// One token takes 50ms at 20 per second.
+	if wait <= 0 || wait > 50*time.Millisecond {
+		t.Fatalf("wait %v, want at most 50ms", wait)
+	}
+
+	time.Sleep(wait + 10*time.Millisecond)
+	if ok, _ := l.Allow("a", "/get"); !ok {
+		t.Fatal("request denied after the bucket refilled")
+	}
+	if ok, _ := l.Allow("a", "/get"); ok {
+		t.Fatal("refill gave more than one token")
+	}
+
+	// The bucket never holds more than the burst.
+	time.Sleep(200 * time.Millisecond)
+	allowed := 0
+	for range 5 {
+		if ok, _ := l.Allow("a", "/get"); ok {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("%d requests allowed after idling, want the burst of 2", allowed)
+	}
+}
+
+func TestBucketsPerClientAndEndpoint(t *testing.T) {
+	l := New(Settings{
+		Enabled:   true,
+		Default:   Limit{Rate: 1, Burst: 1},
+		Endpoints: map[string]Limit{"/put": {Rate: 1, Burst: 3}},
+		Clients:   map[string]Limit{"vip": {Rate: 0}},
+	})
+
+	burst := func(client, endpoint string) int {
+		n := 0
+		for range 10 {
+			if ok, _ := l.Allow(client, endpoint); ok {
+				n++
+			}
+		}
+		return n
+	}
+	for _, tc := range []struct {
+		client, endpoint string
+		want             int
+	}{
+		{"a", "/get", 1},
+		// A separate bucket for another endpoint, with its own limit.
+		{"a", "/put", 3},
+		// And for another client.
+		{"b", "/get", 1},
+		// A client entry wins over the endpoint; rate 0 is unlimited.
+		{"vip", "/put", 10},
+	} {
+		if got := burst(tc.client, tc.endpoint); got != tc.want {
+			t.Errorf("%s %s: %d allowed, want %d", tc.client, tc.endpoint, got, tc.want)
+		}
+	}
+
+	// Replacing the settings starts every bucket full again.
+	l.Set(l.Settings())
+	if ok, _ := l.Allow("a", "/get"); !ok {
+		t.Error("bucket not refilled by Set")
+	}
+
+	l.Set(Settings{Default: Limit{Rate: 1, Burst: 1}})
+	if got := burst("a", "/get"); got != 10 {
+		t.Errorf("disabled limiter allowed %d of 10", got)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	l := New(Settings{})
+	h := l.AdminHandler()
+
+	for _, tc := range []struct {
+		method, body string
+		want         int
+	}{
+		{"PUT", `{"enabled":true,"default":{"rate":5,"burst":5}}`, http.StatusOK},
+		{"PUT", `{"enabled":true,"default":{"rate":5,"burst":0}}`, http.StatusBadRequest},
+		{"PUT", `{"enabled":true,"limit":1}`, http.StatusBadRequest},
+		{"POST", ``, http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, "/admin/ratelimit", strings.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.body, rec.Code, tc.want)
+		}
+	}
+	if s := l.Settings(); !s.Enabled || s.Default.Rate != 5 {
+		t.Fatalf("settings %+v after PUT", s)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/ratelimit", nil))
+	if rec.Code != http.StatusOK || l.Settings().Enabled {
+		t.Fatalf("DELETE: got %d, enabled %v", rec.Code, l.Settings().Enabled)
+	}
+}
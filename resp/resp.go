@@ -0,0 +1,191 @@
+// Package resp reads Redis commands and writes replies in the Redis
+// serialization protocol, RESP2 or RESP3, so Redis clients can talk to
+// the server.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrProtocol is wrapped by errors for input that is not valid RESP. The
+// connection cannot be read further after one.
+var ErrProtocol = errors.New("resp: protocol error")
+
+func protocolError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, fmt.Sprintf(format, args...))
+}
+
+// maxLine bounds inline commands and the header lines of multibulk
+// commands.
+const maxLine = 64 << 10
+
+// Reader reads commands sent by clients: arrays of bulk strings, or the
+// space-separated inline commands typed into telnet.
+type Reader struct {
+	r *bufio.Reader
+	// max bounds the total size of the arguments of one command.
+	max int
+}
+
+// NewReader returns a Reader whose commands may carry at most max bytes
+// of arguments.
+func NewReader(r io.Reader, max int) *Reader {
+	return &Reader{r: bufio.NewReader(r), max: max}
+}
+
+// Buffered returns how many bytes have been read from the connection but
+// not yet parsed, letting a server hold back flushing replies to
+// pipelined commands.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+func (r *Reader) line() (string, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxLine {
+		return "", protocolError("line too long")
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// ReadCommand returns the next command's name and arguments. It skips
+// empty inline lines.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.line()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			continue
+		}
+		if line[0] != '*' {
+			return strings.Fields(line), nil
+		}
+
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > r.max {
+			return nil, protocolError("invalid multibulk length")
+		}
+		if n <= 0 {
+			continue
+		}
+
+		// n is only a claim until the arguments arrive, so it does not size
+		// the allocation on its own.
+		args := make([]string, 0, min(n, 64))
+		total := 0
+		for range n {
+			line, err := r.line()
+			if err != nil {
+				return nil, err
+			}
+			if line == "" || line[0] != '$' {
+				return nil, protocolError("expected '$', got %q", line)
+			}
+			size, err := strconv.Atoi(line[1:])
+			if err != nil || size < 0 {
+				return nil, protocolError("invalid bulk length")
+			}
+			if total += size; total > r.max {
+				return nil, protocolError("command exceeds %d bytes", r.max)
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(r.r, buf); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			if buf[size] != '\r' || buf[size+1] != '\n' {
+				return nil, protocolError("bulk string not terminated by CRLF")
+			}
+			args = append(args, string(buf[:size]))
+		}
+		return args, nil
+	}
+}
+
+// Writer writes replies in RESP2 or, once Proto is 3, RESP3. The two only
+// differ here in how nulls and maps are sent.
+type Writer struct {
+	w     *bufio.Writer
+	Proto int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), Proto: 2}
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// SimpleString writes a status reply such as OK.
+func (w *Writer) SimpleString(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// Error writes an error reply. msg starts with an upper-case error code,
+// such as "ERR" or "NOAUTH", followed by a space and the message.
+func (w *Writer) Error(msg string) {
+	w.w.WriteByte('-')
+	// Line breaks would end the reply early.
+	w.w.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) Int(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) Bulk(s string) {
+	w.w.WriteByte('$')
+	w.w.WriteString(strconv.Itoa(len(s)))
+	w.w.WriteString("\r\n")
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// Null writes the reply for a missing value.
+func (w *Writer) Null() {
+	if w.Proto >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// Array starts an array reply of n elements, which are written next.
+func (w *Writer) Array(n int) {
+	w.w.WriteByte('*')
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+// Map starts a map reply of n key value pairs, which are written next as
+// 2n elements. RESP2 has no maps, so it gets a flat array.
+func (w *Writer) Map(n int) {
+	if w.Proto >= 3 {
+		w.w.WriteByte('%')
+		w.w.WriteString(strconv.Itoa(n))
+		w.w.WriteString("\r\n")
+		return
+	}
+	w.Array(2 * n)
+}
@@ -0,0 +1,48 @@
+package resp
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\n1\r\n", []string{"GET", "1"}, false},
+		{"PING extra\r\n", []string{"PING", "extra"}, false},
+		{"\r\n*0\r\n*1\r\n$4\r\nPING\r\n", []string{"PING"}, false},
+		{"*1\r\n$-1\r\n", nil, true},
+		{"*1\r\n+GET\r\n", nil, true},
+		{"*x\r\n", nil, true},
+		// Over the command size limit of 64 bytes.
+		{"*65\r\n", nil, true},
+		{"*1\r\n$65\r\n", nil, true},
+		// A count the arguments never follow.
+		{"*64\r\n$1\r\na\r\n", nil, true},
+	} {
+		got, err := NewReader(strings.NewReader(tc.in), 64).ReadCommand()
+		if (err != nil) != tc.wantErr || !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, %v; want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestReadCommandDoesNotTrustCount(t *testing.T) {
+	// A client that declares a million arguments and sends one must not
+	// make the server allocate room for a million.
+	r := NewReader(strings.NewReader("*1000000\r\n$1\r\na\r\n"), 1<<30)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := r.ReadCommand(); err == nil {
+		t.Fatal("truncated command accepted")
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes for one argument", n)
+	}
+}
@@ -0,0 +1,11 @@
+-- KeyValue holds every pair for the mysql backend. version starts at 1 and
+-- is bumped by each write to the key.
+CREATE TABLE IF NOT EXISTS KeyValue (
+    id      BIGINT          NOT NULL PRIMARY KEY,
+    value   MEDIUMTEXT      NOT NULL,
+    version BIGINT UNSIGNED NOT NULL DEFAULT 1
+);
+
+-- Tables created before versions were tracked need the column added:
+--
+--   ALTER TABLE KeyValue ADD COLUMN version BIGINT UNSIGNED NOT NULL DEFAULT 1;
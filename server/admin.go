@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// defaultTopKeys is how many keys /debug/cache lists without ?n=.
+const defaultTopKeys = 100
+
+// AdminHandler serves the debugging and tuning endpoints. It is only ever
+// exposed on the admin listener, never on the public address, and
+// requireAdmin guards every endpoint.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/goroutines", s.goroutines)
+	mux.HandleFunc("/debug/cache", s.cacheDump)
+	mux.HandleFunc("/admin/cache/clear", s.clearCache)
+	mux.HandleFunc("/debug/runtime", s.runtimeStats)
+	mux.Handle("/admin/inject", s.inject.AdminHandler())
+	mux.HandleFunc("/admin/acl/reload", s.reloadACL)
+	mux.Handle("/admin/ratelimit", s.limiter.AdminHandler())
+	mux.HandleFunc("/admin/quotas", s.quotaUsage)
+	mux.HandleFunc("/admin/reencrypt", s.reencryptNow)
+	mux.HandleFunc("/admin/audit", s.auditQuery)
+
+	return s.requireAdmin(mux)
+}
+
+// goroutines writes a full stack dump of every goroutine.
+func (s *Server) goroutines(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// cacheDump lists the top ?n= cached keys, most recently used first.
+func (s *Server) cacheDump(w http.ResponseWriter, req *http.Request) {
+	n := defaultTopKeys
+	if v := req.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "Invalid 'n' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stats := s.cache.Stats()
+	writeJSON(w, map[string]any{
+		"size":      s.cache.Len(),
+		"capacity":  s.cache.Capacity(),
+		"hits":      stats.Hits,
+		"misses":    stats.Misses,
+		"evictions": stats.Evictions,
+		"keys":      s.cache.Keys(n),
+	})
+}
+
+// clearCache drops every cached value, e.g. so no plaintext copies stay
+// in memory after encryption is turned on.
+func (s *Server) clearCache(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]any{"cleared": s.cache.Clear()})
+}
+
+// runtimeStats reports memory and garbage collector statistics.
+func (s *Server) runtimeStats(w http.ResponseWriter, req *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	pauses := make([]string, 0, len(gc.Pause))
+	for _, p := range gc.Pause {
+		pauses = append(pauses, p.String())
+	}
+
+	writeJSON(w, map[string]any{
+		"goroutines":      runtime.NumGoroutine(),
+		"gomaxprocs":      runtime.GOMAXPROCS(0),
+		"heap_alloc":      mem.HeapAlloc,
+		"heap_inuse":      mem.HeapInuse,
+		"heap_objects":    mem.HeapObjects,
+		"sys":             mem.Sys,
+		"total_alloc":     mem.TotalAlloc,
+		"next_gc":         mem.NextGC,
+		"num_gc":          gc.NumGC,
+		"last_gc":         gc.LastGC.Format(time.RFC3339Nano),
+		"pause_total":     gc.PauseTotal.String(),
+		"recent_pauses":   pauses,
+		"gc_cpu_fraction": mem.GCCPUFraction,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"decsproject/auth"
+	"decsproject/config"
+)
+
+const adminACL = `
+rules:
+  - principal: ops
+    operations: [admin]
+    keys: ["*"]
+  - principal: oncall
+    operations: [admin]
+    keys: ["debug.*"]
+  - principal: reader
+    operations: [get, admin]
+    keys: ["*"]
+`
+
+func TestRequireAdminRejectsNonAdminCallers(t *testing.T) {
+	cfg := testConfig()
+	cfg.ACL.File = filepath.Join(t.TempDir(), "acl.yaml")
+	if err := os.WriteFile(cfg.ACL.File, []byte(adminACL), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth.Enabled = true
+	cfg.Auth.APIKeys = []config.APIKey{
+		{Name: "ops", Key: "ops-key", Scopes: []string{auth.ScopeAdmin}},
+		{Name: "oncall", Key: "oncall-key", Scopes: []string{auth.ScopeAdmin}},
+		// The ACL would allow it, but the key lacks the admin scope.
+		{Name: "reader", Key: "reader-key", Scopes: []string{auth.ScopeRead}},
+		// The key has the scope, but the ACL grants it nothing.
+		{Name: "team-a", Key: "team-a-key", Scopes: []string{auth.ScopeAdmin}},
+	}
+	s := newTestServer(t, cfg)
+	h := s.AdminHandler()
+
+	for _, tc := range []struct {
+		key, method, path string
+		want              int
+	}{
+		{"", "GET", "/debug/cache", http.StatusUnauthorized},
+		{"wrong-key", "GET", "/debug/cache", http.StatusUnauthorized},
+		{"reader-key", "GET", "/debug/cache", http.StatusForbidden},
+		{"team-a-key", "GET", "/debug/cache", http.StatusForbidden},
+		{"oncall-key", "POST", "/admin/cache/clear", http.StatusForbidden},
+		{"oncall-key", "GET", "/debug/cache", http.StatusOK},
+		{"ops-key", "POST", "/admin/cache/clear", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.key != "" {
+			req.Header.Set(auth.APIKeyHeader, tc.key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s with %q: got %d %q, want %d", tc.method, tc.path, tc.key, rec.Code, rec.Body.String(), tc.want)
+		}
+	}
+
+	// Without auth the caller is anonymous, which this ACL does not let
+	// in.
+	cfg.Auth = config.AuthConfig{}
+	rec := httptest.NewRecorder()
+	newTestServer(t, cfg).AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/cache", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("anonymous: got %d, want 403", rec.Code)
+	}
+}
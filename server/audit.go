@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"decsproject/audit"
+	"decsproject/logging"
+)
+
+// defaultAuditLimit is how many records /admin/audit returns without
+// ?limit=.
+const defaultAuditLimit = 1000
+
+// recordAudit appends a mutation that has been applied to the audit log,
+// if one is configured.
+func (s *Server) recordAudit(ctx context.Context, op string, key int, oldVersion, newVersion uint64) {
+	if s.audit == nil {
+		return
+	}
+
+	err := s.audit.Write(audit.Record{
+		Time:       time.Now().UTC(),
+		Principal:  principalName(ctx),
+		Operation:  op,
+		Key:        key,
+		OldVersion: oldVersion,
+		NewVersion: newVersion,
+		RequestID:  logging.RequestID(ctx),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to write audit record", "op", op, "key", key, "err", err)
+	}
+}
+
+// auditQuery returns audit records filtered by ?key=, ?from= and ?to=
+// (RFC 3339, to exclusive), oldest first, limited to the newest ?limit=.
+func (s *Server) auditQuery(w http.ResponseWriter, req *http.Request) {
+	if s.audit == nil {
+		http.Error(w, "No audit log configured", http.StatusNotFound)
+		return
+	}
+
+	q := req.URL.Query()
+	f := audit.Filter{Limit: defaultAuditLimit}
+	if v := q.Get("key"); v != "" {
+		key, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid 'key' parameter", http.StatusBadRequest)
+			return
+		}
+		f.Key = &key
+	}
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid '"+name+"' parameter, want RFC 3339", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			http.Error(w, "Invalid 'limit' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := s.audit.Query(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, records)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"decsproject/acl"
+	"decsproject/auth"
+)
+
+// require wraps a key value handler so it only runs for clients holding
+// scope. Without auth.enabled every request is let through.
+func (s *Server) require(scope string, next http.HandlerFunc) http.Handler {
+	if !s.cfg.Auth.Enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok := s.authenticate(w, req, scope)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(auth.WithPrincipal(req.Context(), p)))
+	})
+}
+
+// authenticate checks the request's credentials and that they hold scope,
+// answering 401 or 403 if not.
+func (s *Server) authenticate(w http.ResponseWriter, req *http.Request, scope string) (*auth.Principal, bool) {
+	p, err := s.auth.Authenticate(req)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kv"`)
+		if err == auth.ErrNoCredentials {
+			http.Error(w, "Missing credentials", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		}
+		return nil, false
+	}
+
+	info(req.Context()).principal = p.Name
+	if !p.HasScope(scope) {
+		http.Error(w, "Forbidden: missing '"+scope+"' scope", http.StatusForbidden)
+		return nil, false
+	}
+	return p, true
+}
+
+// requireAdmin guards the admin listener. The ACL must allow the admin
+// operation on the endpoint's name, its path with dots for slashes (e.g.
+// "admin.cache.clear"), and with auth.enabled the client must
+// authenticate with the admin scope.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if s.cfg.Auth.Enabled {
+			p, ok := s.authenticate(w, req, auth.ScopeAdmin)
+			if !ok {
+				return
+			}
+			ctx = auth.WithPrincipal(ctx, p)
+		}
+		name := strings.ReplaceAll(strings.Trim(req.URL.Path, "/"), "/", ".")
+		if err := s.allowed(ctx, acl.OpAdmin, name); err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// reloadACL re-reads the ACL file on demand.
+func (s *Server) reloadACL(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.acl == nil {
+		http.Error(w, "No ACL file configured", http.StatusNotFound)
+		return
+	}
+	if err := s.acl.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "ACL reloaded")
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"decsproject/acl"
+	"decsproject/auth"
+	"decsproject/binproto"
+	"decsproject/store"
+	"decsproject/tracing"
+)
+
+// binaryProtocol labels binary protocol requests in metrics and logs.
+const binaryProtocol = "binary"
+
+// binConn is one binary protocol connection. A reader goroutine decodes
+// requests and runs each in its own goroutine, up to
+// binary.max_in_flight at a time; a writer goroutine sends the responses
+// in the order they complete.
+type binConn struct {
+	streamConn
+	s *Server
+
+	out      chan binproto.Frame
+	inflight chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (s *Server) newBinConn(conn net.Conn) protoConn {
+	return &binConn{
+		streamConn: newStreamConn(conn, s.cfg.Server.IdleTimeout),
+		s:          s,
+		out:        make(chan binproto.Frame, s.cfg.Binary.MaxInFlight),
+		inflight:   make(chan struct{}, s.cfg.Binary.MaxInFlight),
+	}
+}
+
+func (c *binConn) serve() {
+	written := make(chan struct{})
+	go c.writeLoop(written)
+
+	r := bufio.NewReader(c.conn)
+	for c.awaitRequest() {
+		f, err := binproto.ReadFrame(r, c.s.cfg.Binary.MaxFrameBytes)
+		if err == binproto.ErrFrameTooLarge {
+			// The rest of the frame is not read, so the stream cannot be
+			// resynchronised.
+			c.reply(f.ID, binproto.StatusTooLarge, []byte("frame exceeds "+strconv.Itoa(c.s.cfg.Binary.MaxFrameBytes)+" bytes"))
+			break
+		}
+		if err != nil {
+			if !quietReadError(err) {
+				slog.Debug("Binary protocol read failed", "remote", c.client, "err", err)
+			}
+			break
+		}
+
+		if binproto.Op(f.Code) == binproto.OpAuth {
+			// Handled before reading on so every later request runs as
+			// the new principal.
+			c.authenticate(f)
+			continue
+		}
+
+		// The context is built here rather than in the request's
+		// goroutine, so it has the principal of every OpAuth read before
+		// it and its deadline runs from when it was read.
+		req := c.begin(f)
+		c.inflight <- struct{}{}
+		c.wg.Add(1)
+		go func() {
+			defer func() {
+				req.cancel()
+				<-c.inflight
+				c.wg.Done()
+			}()
+			c.handle(req)
+		}()
+	}
+
+	c.wg.Wait()
+	close(c.out)
+	<-written
+	c.conn.Close()
+}
+
+// writeLoop sends responses, flushing whenever it catches up so pipelined
+// responses share writes.
+func (c *binConn) writeLoop(done chan<- struct{}) {
+	defer close(done)
+	w := bufio.NewWriter(c.conn)
+	var buf []byte
+	failed := false
+	for f := range c.out {
+		if failed {
+			continue
+		}
+		if d := c.s.cfg.Server.WriteTimeout; d > 0 {
+			c.conn.SetWriteDeadline(time.Now().Add(d))
+		}
+		buf = binproto.AppendFrame(buf[:0], f)
+		_, err := w.Write(buf)
+		if err == nil && len(c.out) == 0 {
+			err = w.Flush()
+		}
+		if err != nil {
+			// Keep draining so handlers do not block; closing the
+			// connection stops the reader.
+			failed = true
+			c.conn.Close()
+		}
+	}
+	if !failed {
+		w.Flush()
+	}
+}
+
+func (c *binConn) reply(id uint32, status binproto.Status, body []byte) {
+	c.out <- binproto.Frame{ID: id, Code: uint8(status), Body: body}
+}
+
+// authenticate handles OpAuth. Without auth.enabled credentials are not
+// needed and the connection stays anonymous.
+func (c *binConn) authenticate(f binproto.Frame) {
+	ctx, span := tracing.Start(c.context(), "binary auth", tracing.KindServer)
+	start := time.Now()
+
+	var (
+		p   *auth.Principal
+		err error
+	)
+	switch {
+	case !c.s.cfg.Auth.Enabled:
+	case len(f.Body) < 2:
+		err = &badRequestError{"malformed auth request"}
+	case f.Body[0] == binproto.AuthAPIKey:
+		p, err = c.s.auth.AuthenticateKey(string(f.Body[1:]))
+	case f.Body[0] == binproto.AuthToken:
+		p, err = c.s.auth.AuthenticateToken(string(f.Body[1:]))
+	default:
+		err = &badRequestError{"unknown credential kind"}
+	}
+	// A failed attempt drops any earlier principal.
+	c.principal.Store(p)
+	if p != nil {
+		ctx = auth.WithPrincipal(ctx, p)
+	}
+	c.s.observeOp(ctx, span, binaryProtocol, binproto.OpAuth.String(), "", err, start)
+
+	if err != nil {
+		c.reply(f.ID, binaryStatus(err), binaryMessage(err))
+		return
+	}
+	name := acl.Anonymous
+	if p != nil {
+		name = p.Name
+	}
+	c.reply(f.ID, binproto.StatusOK, []byte(name))
+}
+
+// binRequest is a request other than OpAuth, with the context it runs in.
+type binRequest struct {
+	binproto.Frame
+	ctx    context.Context
+	cancel context.CancelFunc
+	span   *tracing.Span
+	start  time.Time
+}
+
+// begin starts the span for f and gives it the deadline for its op: the
+// server.endpoint_timeouts entry for /<op>, such as /batch, or
+// server.request_timeout. A batch runs all its entries under it.
+func (c *binConn) begin(f binproto.Frame) binRequest {
+	op := binproto.Op(f.Code)
+	ctx, span := tracing.Start(c.context(), "binary "+op.String(), tracing.KindServer)
+	ctx, cancel := context.WithTimeout(ctx, c.s.requestTimeout("/"+op.String()))
+	return binRequest{Frame: f, ctx: ctx, cancel: cancel, span: span, start: time.Now()}
+}
+
+// handle answers one request other than OpAuth.
+func (c *binConn) handle(req binRequest) {
+	op := binproto.Op(req.Code)
+	ctx := req.ctx
+
+	var (
+		body   []byte
+		keyStr string
+		err    error
+	)
+	switch op {
+	case binproto.OpPing:
+	case binproto.OpGet, binproto.OpPut, binproto.OpDelete:
+		var e binproto.Entry
+		if e, err = binproto.DecodeRequest(op, req.Body); err != nil {
+			err = &badRequestError{err.Error()}
+			break
+		}
+		keyStr = strconv.Itoa(e.Key)
+		body, err = c.do(ctx, e)
+	case binproto.OpBatch:
+		body, err = c.batch(ctx, req.Body)
+	default:
+		err = &badRequestError{"unknown op " + strconv.Itoa(int(op))}
+	}
+	c.s.observeOp(ctx, req.span, binaryProtocol, op.String(), keyStr, err, req.start)
+
+	if err != nil {
+		c.reply(req.ID, binaryStatus(err), binaryMessage(err))
+		return
+	}
+	c.reply(req.ID, binproto.StatusOK, body)
+}
+
+// batch runs the entries of an OpBatch in order under the batch's
+// deadline. Entries fail independently; only a malformed batch fails as a
+// whole.
+func (c *binConn) batch(ctx context.Context, body []byte) ([]byte, error) {
+	entries, err := binproto.DecodeBatch(body)
+	if err != nil {
+		return nil, &badRequestError{err.Error()}
+	}
+
+	results := make([]binproto.Result, len(entries))
+	for i, e := range entries {
+		body, err := c.do(ctx, e)
+		if err != nil {
+			if opResult(err) == resultError {
+				slog.ErrorContext(ctx, "Binary protocol batch entry failed", "op", e.Op.String(), "key", e.Key, "err", err)
+			}
+			results[i] = binproto.Result{Status: binaryStatus(err), Body: binaryMessage(err)}
+			continue
+		}
+		results[i] = binproto.Result{Status: binproto.StatusOK, Body: body}
+	}
+	return binproto.EncodeBatchResults(results), nil
+}
+
+// do runs one get, put or delete, applying the same scope, rate limit and
+// size checks as the HTTP API.
+func (c *binConn) do(ctx context.Context, e binproto.Entry) ([]byte, error) {
+	scope := auth.ScopeWrite
+	if e.Op == binproto.OpGet {
+		scope = auth.ScopeRead
+	}
+	if err := c.s.checkScope(ctx, scope); err != nil {
+		return nil, err
+	}
+	if err := c.s.limit(ctx, c.client, "/"+e.Op.String()); err != nil {
+		return nil, err
+	}
+	if err := c.s.checkKey(e.Key); err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case binproto.OpGet:
+		value, _, err := c.s.getValue(ctx, e.Key)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(value), nil
+	case binproto.OpPut:
+		if e.Value == "" {
+			return nil, &badRequestError{"missing or empty value"}
+		}
+		if err := c.s.checkValue(e.Value); err != nil {
+			return nil, err
+		}
+		version, err := c.s.putValue(ctx, e.Key, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return binproto.EncodeVersion(version), nil
+	case binproto.OpDelete:
+		version, err := c.s.deleteValue(ctx, e.Key)
+		if err != nil {
+			return nil, err
+		}
+		return binproto.EncodeVersion(version), nil
+	}
+	return nil, &badRequestError{e.Op.String() + " cannot be batched"}
+}
+
+// binaryStatus maps an error from the shared operations to a status.
+func binaryStatus(err error) binproto.Status {
+	switch opResult(err) {
+	case resultOK:
+		return binproto.StatusOK
+	case resultNotFound:
+		return binproto.StatusNotFound
+	case resultBadRequest:
+		return binproto.StatusBadRequest
+	case resultUnauthorized:
+		return binproto.StatusUnauthorized
+	case resultForbidden:
+		return binproto.StatusForbidden
+	case resultTooLarge:
+		return binproto.StatusTooLarge
+	case resultRateLimited:
+		return binproto.StatusRateLimited
+	case resultUnavailable:
+		return binproto.StatusUnavailable
+	case resultTimeout:
+		return binproto.StatusTimeout
+	default:
+		return binproto.StatusError
+	}
+}
+
+// binaryMessage is the message sent with an error status. Details of
+// internal errors stay in the server log.
+func binaryMessage(err error) []byte {
+	var limited *rateLimitedError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return []byte("key is not present")
+	case errors.As(err, &limited):
+		return []byte("rate limit exceeded, retry after " + strconv.Itoa(limited.retryAfter()) + "s")
+	case errors.Is(err, context.DeadlineExceeded):
+		return []byte("request deadline exceeded")
+	}
+	if opResult(err) == resultError {
+		return []byte("internal error")
+	}
+	return []byte(err.Error())
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"decsproject/binproto"
+	"decsproject/config"
+	"decsproject/store"
+)
+
+// binaryConn sends raw frames to one binary protocol connection served
+// from s over an in-memory pipe, so tests control how they are pipelined.
+type binaryConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialBinary(t *testing.T, s *Server) *binaryConn {
+	t.Helper()
+	client, server := net.Pipe()
+	c := s.newBinConn(server)
+	done := make(chan struct{})
+	go func() {
+		c.serve()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	return &binaryConn{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+// send writes frames in one write.
+func (c *binaryConn) send(frames ...binproto.Frame) {
+	c.t.Helper()
+	var buf []byte
+	for _, f := range frames {
+		buf = binproto.AppendFrame(buf, f)
+	}
+	// net.Pipe writes block until read, and the server may be writing
+	// responses back meanwhile.
+	go c.conn.Write(buf)
+}
+
+func (c *binaryConn) recv() binproto.Frame {
+	c.t.Helper()
+	f, err := binproto.ReadFrame(c.r, 1<<20)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return f
+}
+
+// recvAll reads n responses and returns them by ID.
+func (c *binaryConn) recvAll(n int) map[uint32]binproto.Frame {
+	c.t.Helper()
+	out := make(map[uint32]binproto.Frame, n)
+	for range n {
+		f := c.recv()
+		out[f.ID] = f
+	}
+	return out
+}
+
+func request(id uint32, op binproto.Op, body []byte) binproto.Frame {
+	return binproto.Frame{ID: id, Code: uint8(op), Body: body}
+}
+
+func TestBinaryOperations(t *testing.T) {
+	c := dialBinary(t, newTestServer(t, testConfig()))
+
+	c.send(
+		request(1, binproto.OpPut, binproto.EncodePut(1, "one")),
+		request(2, binproto.OpGet, binproto.EncodeKey(1)),
+		request(3, binproto.OpGet, binproto.EncodeKey(2)),
+		request(4, binproto.OpPing, nil),
+		request(5, binproto.OpGet, []byte{1}),
+		request(6, 99, nil),
+		request(7, binproto.OpPut, binproto.EncodePut(2, "")),
+	)
+	got := c.recvAll(7)
+	// The put and the get of key 1 may run in either order.
+	if f := got[2]; f.Code != uint8(binproto.StatusOK) && f.Code != uint8(binproto.StatusNotFound) {
+		t.Errorf("get 1: status %d", f.Code)
+	}
+	for id, want := range map[uint32]binproto.Status{
+		1: binproto.StatusOK,
+		3: binproto.StatusNotFound,
+		4: binproto.StatusOK,
+		5: binproto.StatusBadRequest,
+		6: binproto.StatusBadRequest,
+		7: binproto.StatusBadRequest,
+	} {
+		if f := got[id]; binproto.Status(f.Code) != want {
+			t.Errorf("request %d: got %s %q, want %s", id, binproto.Status(f.Code), f.Body, want)
+		}
+	}
+	if v, _ := binproto.DecodeVersion(got[1].Body); v != 1 {
+		t.Errorf("put: version %d, want 1", v)
+	}
+
+	entries := []binproto.Entry{
+		{Op: binproto.OpPut, Key: 3, Value: "three"},
+		{Op: binproto.OpGet, Key: 3},
+		{Op: binproto.OpDelete, Key: 3},
+		{Op: binproto.OpGet, Key: 3},
+		{Op: binproto.OpPut, Key: 4, Value: ""},
+	}
+	body, err := binproto.EncodeBatch(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send(request(8, binproto.OpBatch, body))
+	f := c.recv()
+	results, err := binproto.DecodeBatchResults(f.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Batch entries run in order, and fail on their own.
+	want := []binproto.Status{binproto.StatusOK, binproto.StatusOK, binproto.StatusOK, binproto.StatusNotFound, binproto.StatusBadRequest}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("batch entry %d: got %s %q, want %s", i, r.Status, r.Body, want[i])
+		}
+	}
+	if len(results) != len(want) || results[1].Value() != "three" || results[2].Version() != 1 {
+		t.Errorf("batch results %+v", results)
+	}
+
+	c.send(request(9, binproto.OpBatch, []byte{0}))
+	if f := c.recv(); binproto.Status(f.Code) != binproto.StatusBadRequest {
+		t.Errorf("malformed batch: got %s", binproto.Status(f.Code))
+	}
+}
+
+func TestBinaryRepliesOutOfOrder(t *testing.T) {
+	st := &heldStore{
+		Memory:  store.NewMemory(),
+		read:    make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	st.Memory.Put(context.Background(), 1, "one")
+	s, err := New(testConfig(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dialBinary(t, s)
+
+	c.send(request(1, binproto.OpGet, binproto.EncodeKey(1)))
+	<-st.read
+	// The get is held; a ping sent after it is answered first.
+	c.send(request(2, binproto.OpPing, nil))
+	if f := c.recv(); f.ID != 2 {
+		t.Fatalf("first response is for request %d, want 2", f.ID)
+	}
+	close(st.release)
+	if f := c.recv(); f.ID != 1 || string(f.Body) != "one" {
+		t.Fatalf("second response: got %d %q, want 1 \"one\"", f.ID, f.Body)
+	}
+}
+
+func TestBinaryPipelinedAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.Enabled = true
+	cfg.Auth.APIKeys = []config.APIKey{{Name: "svc", Key: "svc-key"}}
+	s := newTestServer(t, cfg)
+	s.putValue(context.Background(), 1, "one")
+
+	apiKey := func(key string) []byte {
+		return append([]byte{binproto.AuthAPIKey}, key...)
+	}
+	// Requests run in their own goroutines, so repeat to give a request
+	// that picked up the principal late the chance to show.
+	for range 50 {
+		c := dialBinary(t, s)
+		c.send(
+			request(1, binproto.OpGet, binproto.EncodeKey(1)),
+			request(2, binproto.OpAuth, apiKey("svc-key")),
+			request(3, binproto.OpGet, binproto.EncodeKey(1)),
+			request(4, binproto.OpAuth, apiKey("wrong")),
+			request(5, binproto.OpGet, binproto.EncodeKey(1)),
+		)
+		got := c.recvAll(5)
+		// Each get runs as whoever the connection was authenticated as
+		// when it was read.
+		for id, want := range map[uint32]binproto.Status{
+			1: binproto.StatusUnauthorized,
+			2: binproto.StatusOK,
+			3: binproto.StatusOK,
+			4: binproto.StatusUnauthorized,
+			5: binproto.StatusUnauthorized,
+		} {
+			if f := got[id]; binproto.Status(f.Code) != want {
+				t.Fatalf("request %d: got %s %q, want %s", id, binproto.Status(f.Code), f.Body, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"decsproject/store"
+)
+
+// reencrypt re-seals values under old keys in the background after a
+// rotation.
+func (s *Server) reencrypt(ctx context.Context) {
+	start := time.Now()
+	n, err := store.Encryption(s.store).Reencrypt(ctx)
+	if err == store.ErrPreloadUnsupported {
+		slog.Warn("Re-encryption is not supported by this backend", "backend", s.cfg.Storage.Backend)
+		return
+	}
+	if err != nil {
+		slog.Error("Re-encryption failed", "rewritten", n, "err", err)
+		return
+	}
+	slog.Info("Re-encryption finished", "rewritten", n, "elapsed", time.Since(start).String())
+}
+
+// reencryptNow runs the re-encryption job on demand and reports how many
+// values it rewrote.
+func (s *Server) reencryptNow(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	enc := store.Encryption(s.store)
+	if enc == nil {
+		http.Error(w, "Encryption is not configured", http.StatusNotFound)
+		return
+	}
+
+	n, err := enc.Reencrypt(req.Context())
+	if err == store.ErrPreloadUnsupported {
+		http.Error(w, "Re-encryption is not supported by the "+s.cfg.Storage.Backend+" backend", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"rewritten": n})
+}
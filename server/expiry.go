@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"decsproject/audit"
+	"decsproject/auth"
+	"decsproject/store"
+)
+
+// expirySweepInterval is how often keys past their deadline are removed
+// when nobody reads them.
+const expirySweepInterval = time.Second
+
+// expiryPrincipal is the principal recorded in the audit log for keys
+// removed because they expired.
+var expiryPrincipal = &auth.Principal{Name: "expiry"}
+
+// expiries holds the deadlines set on keys by protocols with a time to
+// live, such as the RESP EXPIRE command. The store has no notion of
+// expiry, so they live in memory only and a restart forgets them.
+type expiries struct {
+	mu sync.Mutex
+	at map[int]time.Time
+}
+
+func newExpiries() *expiries {
+	return &expiries{at: make(map[int]time.Time)}
+}
+
+func (e *expiries) set(key int, at time.Time) {
+	e.mu.Lock()
+	e.at[key] = at
+	e.mu.Unlock()
+}
+
+func (e *expiries) clear(key int) {
+	e.mu.Lock()
+	delete(e.at, key)
+	e.mu.Unlock()
+}
+
+// get returns the deadline of key, if it has one.
+func (e *expiries) get(key int) (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	at, found := e.at[key]
+	return at, found
+}
+
+// due returns the keys whose deadline is not after now.
+func (e *expiries) due(now time.Time) []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var keys []int
+	for key, at := range e.at {
+		if !at.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// expired reports whether key is past its deadline. Readers treat such
+// keys as absent until sweepExpired removes them.
+func (s *Server) expired(key int) bool {
+	at, found := s.expiry.get(key)
+	return found && !at.After(time.Now())
+}
+
+// expire removes key on behalf of expiryPrincipal, unless its deadline was
+// cleared or moved since it came due.
+func (s *Server) expire(ctx context.Context, key int) {
+	unlock := s.lock(key)
+	defer unlock()
+	if !s.expired(key) {
+		return
+	}
+
+	ctx = auth.WithPrincipal(ctx, expiryPrincipal)
+	s.warming.wrote(key)
+	s.casTokens.forget(key)
+	version, err := s.store.Delete(ctx, key)
+	if err != nil && err != store.ErrNotFound {
+		// Left in place for the next sweep.
+		slog.WarnContext(ctx, "Failed to remove expired key", "key", key, "err", err)
+		return
+	}
+	s.casTokens.forget(key)
+
+	s.expiry.clear(key)
+	s.cache.DeleteKey(strconv.Itoa(key))
+	if err == nil {
+		s.recordAudit(ctx, audit.OpDelete, key, version, 0)
+		s.quota.Remove(key)
+		s.feed.publish(change{deleted: true, key: key, version: version})
+	}
+}
+
+// sweepExpired removes expired keys every expirySweepInterval until ctx is
+// cancelled.
+func (s *Server) sweepExpired(ctx context.Context) {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, key := range s.expiry.due(now) {
+				s.expire(ctx, key)
+			}
+		}
+	}
+}
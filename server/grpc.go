@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"decsproject/acl"
+	"decsproject/auth"
+	"decsproject/kvpb"
+	"decsproject/logging"
+	"decsproject/quota"
+	"decsproject/store"
+	"decsproject/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcProtocol labels gRPC requests in metrics and logs.
+const grpcProtocol = "grpc"
+
+// Scan page sizes: the default and the most one call may ask for.
+const (
+	grpcScanLimit    = 100
+	grpcMaxScanLimit = 1000
+)
+
+// errWatchBehind ends a Watch stream whose client does not keep up.
+var errWatchBehind = &limitError{"watcher fell more than " + strconv.Itoa(watchBuffer) + " changes behind"}
+
+// listenGRPC starts the gRPC API on addr, over TLS if it is configured.
+func (s *Server) listenGRPC(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Protocol server running", "protocol", grpcProtocol, "addr", l.Addr().String(), "tls", s.tls != nil)
+	s.serveGRPC(ctx, l)
+	return nil
+}
+
+// serveGRPC serves the gRPC API on l. When ctx is cancelled Watch streams
+// end, and calls in flight get up to server.shutdown_timeout to finish
+// before Serve closes the store.
+func (s *Server) serveGRPC(ctx context.Context, l net.Listener) {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.grpcUnary),
+		grpc.StreamInterceptor(s.grpcStream),
+		grpc.MaxRecvMsgSize(s.cfg.Server.MaxBodyBytes),
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: s.cfg.Server.IdleTimeout}),
+	}
+	if s.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tls)))
+	}
+	srv := grpc.NewServer(opts...)
+	kvpb.RegisterKVServer(srv, &kvService{s: s, stop: ctx.Done()})
+
+	s.protocols.Add(1)
+	go func() {
+		defer s.protocols.Done()
+		go func() {
+			if err := srv.Serve(l); err != nil {
+				slog.Error("Protocol listener failed", "protocol", grpcProtocol, "err", err)
+			}
+		}()
+
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(s.cfg.Server.ShutdownTimeout):
+			slog.Warn("Drain deadline exceeded, closing remaining connections", "protocol", grpcProtocol)
+			srv.Stop()
+		}
+	}()
+}
+
+// grpcContext prepares the context of a call as observe does for HTTP: a
+// request ID, the caller's trace from a traceparent entry and, with
+// auth.enabled, the principal named by its x-api-key or authorization
+// entry. Invalid credentials fail the call; missing ones fail it later,
+// in checkScope.
+func (s *Server) grpcContext(ctx context.Context) (context.Context, error) {
+	ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	md, _ := metadata.FromIncomingContext(ctx)
+	if sc, err := tracing.ParseTraceParent(mdValue(md, "traceparent")); err == nil {
+		ctx = tracing.ContextWithRemote(ctx, sc)
+	}
+	if !s.cfg.Auth.Enabled {
+		return ctx, nil
+	}
+
+	var (
+		p   *auth.Principal
+		err error
+	)
+	if key := mdValue(md, strings.ToLower(auth.APIKeyHeader)); key != "" {
+		p, err = s.auth.AuthenticateKey(key)
+	} else if authz := mdValue(md, "authorization"); authz != "" {
+		scheme, token, found := strings.Cut(authz, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return ctx, auth.ErrInvalidCredentials
+		}
+		p, err = s.auth.AuthenticateToken(token)
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if p != nil {
+		ctx = auth.WithPrincipal(ctx, p)
+	}
+	return ctx, nil
+}
+
+// mdValue returns the first value of key in md, or "".
+func mdValue(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// grpcOp turns a full method name such as /kv.v1.KV/BatchGet into the
+// operation label batchget.
+func grpcOp(fullMethod string) string {
+	return strings.ToLower(fullMethod[strings.LastIndexByte(fullMethod, '/')+1:])
+}
+
+// grpcUnary wraps every unary call: it prepares the context, records the
+// call like observeOp does for the other protocols and maps errors from
+// the shared operations to status codes.
+func (s *Server) grpcUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	op := grpcOp(info.FullMethod)
+	ctx, err := s.grpcContext(ctx)
+	ctx, span := tracing.Start(ctx, "grpc "+op, tracing.KindServer)
+	start := time.Now()
+
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	keyStr := ""
+	if r, ok := req.(interface{ GetKey() int64 }); ok {
+		keyStr = strconv.FormatInt(r.GetKey(), 10)
+	}
+	s.observeOp(ctx, span, grpcProtocol, op, keyStr, err, start)
+
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return resp, nil
+}
+
+// grpcStream is grpcUnary for streaming calls.
+func (s *Server) grpcStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	op := grpcOp(info.FullMethod)
+	ctx, err := s.grpcContext(ss.Context())
+	ctx, span := tracing.Start(ctx, "grpc "+op, tracing.KindServer)
+	start := time.Now()
+
+	if err == nil {
+		err = handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+	}
+	s.observeOp(ctx, span, grpcProtocol, op, "", err, start)
+	return grpcStatus(err)
+}
+
+// grpcServerStream gives a handler the context prepared by grpcStream.
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *grpcServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// grpcStatus maps an error from the shared operations to a status. Details
+// of internal errors stay in the server log.
+func grpcStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var limited *rateLimitedError
+	switch {
+	case errors.Is(err, errWatchBehind):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "call cancelled")
+	}
+
+	switch opResult(err) {
+	case resultNotFound:
+		return status.Error(codes.NotFound, "key is not present")
+	case resultBadRequest, resultTooLarge:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case resultUnauthorized:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case resultForbidden:
+		if errors.Is(err, quota.ErrExceeded) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return status.Error(codes.PermissionDenied, err.Error())
+	case resultRateLimited:
+		errors.As(err, &limited)
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded, retry after "+strconv.Itoa(limited.retryAfter())+"s")
+	case resultConflict:
+		return status.Error(codes.FailedPrecondition, "key is not at the given version")
+	case resultUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	case resultTimeout:
+		return status.Error(codes.DeadlineExceeded, "request deadline exceeded")
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// kvService implements the gRPC API on the operations shared with HTTP.
+type kvService struct {
+	kvpb.UnimplementedKVServer
+	s *Server
+	// stop is closed when the server shuts down, ending Watch streams.
+	stop <-chan struct{}
+}
+
+// grpcClient identifies an unauthenticated caller to the rate limiter.
+func grpcClient(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	client := p.Addr.String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	return client
+}
+
+// begin applies the scope, rate limit and timeout of the HTTP endpoint
+// matching a call. The deadline is the earlier of the caller's and the
+// endpoint's.
+func (k *kvService) begin(ctx context.Context, endpoint string) (context.Context, context.CancelFunc, error) {
+	scope := auth.ScopeWrite
+	if endpoint == "/get" {
+		scope = auth.ScopeRead
+	}
+	if err := k.s.checkScope(ctx, scope); err != nil {
+		return ctx, func() {}, err
+	}
+	if err := k.s.limit(ctx, grpcClient(ctx), endpoint); err != nil {
+		return ctx, func() {}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, k.s.requestTimeout(endpoint))
+	return ctx, cancel, nil
+}
+
+func (k *kvService) Get(ctx context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	ctx, cancel, err := k.begin(ctx, "/get")
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	key := int(req.Key)
+	if err := k.s.checkKey(key); err != nil {
+		return nil, err
+	}
+	value, _, err := k.s.getValue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.GetResponse{Value: value}, nil
+}
+
+func (k *kvService) Put(ctx context.Context, req *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	ctx, cancel, err := k.begin(ctx, "/put")
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	key := int(req.Key)
+	if err := k.s.checkKey(key); err != nil {
+		return nil, err
+	}
+	if req.Value == "" {
+		return nil, &badRequestError{"missing or empty value"}
+	}
+	if err := k.s.checkValue(req.Value); err != nil {
+		return nil, err
+	}
+	var version uint64
+	if req.IfVersion != 0 {
+		version, err = k.s.casValue(ctx, key, req.Value, req.IfVersion)
+	} else {
+		version, err = k.s.putValue(ctx, key, req.Value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.PutResponse{Version: version}, nil
+}
+
+func (k *kvService) Delete(ctx context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	ctx, cancel, err := k.begin(ctx, "/delete")
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	key := int(req.Key)
+	if err := k.s.checkKey(key); err != nil {
+		return nil, err
+	}
+	version, err := k.s.deleteValue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &kvpb.DeleteResponse{Version: version}, nil
+}
+
+// BatchGet runs under the /batch timeout, like a binary protocol batch,
+// and takes one /get rate limit token per key.
+func (k *kvService) BatchGet(ctx context.Context, req *kvpb.BatchGetRequest) (*kvpb.BatchGetResponse, error) {
+	if err := k.s.checkScope(ctx, auth.ScopeRead); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, k.s.requestTimeout("/batch"))
+	defer cancel()
+
+	items := make([]*kvpb.Item, len(req.Keys))
+	for i, key := range req.Keys {
+		if err := k.s.limit(ctx, grpcClient(ctx), "/get"); err != nil {
+			return nil, err
+		}
+		if err := k.s.checkKey(int(key)); err != nil {
+			return nil, err
+		}
+		value, _, err := k.s.getValue(ctx, int(key))
+		if err != nil && err != store.ErrNotFound {
+			return nil, err
+		}
+		items[i] = &kvpb.Item{Key: key, Found: err == nil, Value: value}
+	}
+	return &kvpb.BatchGetResponse{Items: items}, nil
+}
+
+// Scan leaves out keys the ACL does not let the caller scan, and unless
+// KeysOnly is set those it may not get.
+func (k *kvService) Scan(ctx context.Context, req *kvpb.ScanRequest) (*kvpb.ScanResponse, error) {
+	ctx, cancel, err := k.begin(ctx, "/get")
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	limit := int(req.Limit)
+	switch {
+	case limit < 0:
+		return nil, &badRequestError{"limit must not be negative"}
+	case limit == 0:
+		limit = grpcScanLimit
+	}
+	limit = min(limit, grpcMaxScanLimit)
+
+	keys, err := store.Keys(ctx, k.s.store, int(req.StartKey), limit+1)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kvpb.ScanResponse{}
+	if len(keys) > limit {
+		resp.More = true
+		resp.NextKey = int64(keys[limit])
+		keys = keys[:limit]
+	}
+
+	principal := principalName(ctx)
+	for _, key := range keys {
+		keyStr := strconv.Itoa(key)
+		if !k.s.acl.Allowed(principal, acl.OpScan, keyStr) || k.s.expired(key) {
+			continue
+		}
+		if !req.KeysOnly && !k.s.acl.Allowed(principal, acl.OpGet, keyStr) {
+			continue
+		}
+		item := &kvpb.Item{Key: int64(key), Found: true}
+		if !req.KeysOnly {
+			value, _, err := k.s.getValue(ctx, key)
+			if err == store.ErrNotFound {
+				// Deleted since it was listed.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			item.Value = value
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return resp, nil
+}
+
+// Watch has no deadline; it runs until the client cancels, falls behind
+// or the server shuts down. Changes to keys the caller may not get are
+// left out, and when watching every key also those it may not scan.
+func (k *kvService) Watch(req *kvpb.WatchRequest, stream kvpb.KV_WatchServer) error {
+	ctx := stream.Context()
+	if err := k.s.checkScope(ctx, auth.ScopeRead); err != nil {
+		return err
+	}
+	if err := k.s.limit(ctx, grpcClient(ctx), "/get"); err != nil {
+		return err
+	}
+	keys := make([]int, len(req.Keys))
+	for i, key := range req.Keys {
+		if err := k.s.checkKey(int(key)); err != nil {
+			return err
+		}
+		keys[i] = int(key)
+	}
+
+	w := k.s.feed.watch(keys)
+	defer k.s.feed.cancel(w)
+	// Headers go out now so the client knows it is subscribed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	principal := principalName(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-k.stop:
+			return errShuttingDown
+		case ch, ok := <-w.C:
+			if !ok {
+				return errWatchBehind
+			}
+			keyStr := strconv.Itoa(ch.key)
+			if !k.s.acl.Allowed(principal, acl.OpGet, keyStr) {
+				continue
+			}
+			if len(keys) == 0 && !k.s.acl.Allowed(principal, acl.OpScan, keyStr) {
+				continue
+			}
+			ev := &kvpb.WatchEvent{Type: kvpb.WatchEvent_PUT, Key: int64(ch.key), Value: ch.value, Version: ch.version}
+			if ch.deleted {
+				ev.Type = kvpb.WatchEvent_DELETE
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"decsproject/kvpb"
+	"decsproject/store"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves the gRPC API of s over an in-memory listener and
+// returns a client for it.
+func dialGRPC(t *testing.T, s *Server, opts ...grpc.DialOption) kvpb.KVClient {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.serveGRPC(ctx, l)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		s.protocols.Wait()
+	})
+	return kvpb.NewKVClient(conn)
+}
+
+// wantCode fails the test unless err has status code want.
+func wantCode(t *testing.T, what string, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("%s: got %v (%v), want %v", what, got, err, want)
+	}
+}
+
+// stalledStore never answers a Get before its deadline.
+type stalledStore struct {
+	*store.Memory
+}
+
+func (s *stalledStore) Get(ctx context.Context, key int) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestGRPCPutGetDelete(t *testing.T) {
+	c := dialGRPC(t, newTestServer(t, testConfig()))
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, &kvpb.GetRequest{Key: 1})
+	wantCode(t, "get of a missing key", err, codes.NotFound)
+	_, err = c.Put(ctx, &kvpb.PutRequest{Key: 1})
+	wantCode(t, "put of an empty value", err, codes.InvalidArgument)
+
+	put, err := c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "one"})
+	if err != nil || put.Version != 1 {
+		t.Fatalf("put: version %v, %v", put.GetVersion(), err)
+	}
+	get, err := c.Get(ctx, &kvpb.GetRequest{Key: 1})
+	if err != nil || get.Value != "one" {
+		t.Fatalf("get: %q, %v", get.GetValue(), err)
+	}
+	del, err := c.Delete(ctx, &kvpb.DeleteRequest{Key: 1})
+	if err != nil || del.Version != 1 {
+		t.Fatalf("delete: version %v, %v", del.GetVersion(), err)
+	}
+	_, err = c.Delete(ctx, &kvpb.DeleteRequest{Key: 1})
+	wantCode(t, "delete of a missing key", err, codes.NotFound)
+}
+
+func TestGRPCConditionalPut(t *testing.T) {
+	c := dialGRPC(t, newTestServer(t, testConfig()))
+	ctx := context.Background()
+
+	_, err := c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "a", IfVersion: 1})
+	wantCode(t, "conditional put of a missing key", err, codes.NotFound)
+	if _, err := c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	put, err := c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "b", IfVersion: 1})
+	if err != nil || put.Version != 2 {
+		t.Fatalf("conditional put at the current version: version %v, %v", put.GetVersion(), err)
+	}
+	_, err = c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "c", IfVersion: 1})
+	wantCode(t, "conditional put at an old version", err, codes.FailedPrecondition)
+
+	get, err := c.Get(ctx, &kvpb.GetRequest{Key: 1})
+	if err != nil || get.Value != "b" {
+		t.Fatalf("get after a lost conditional put: %q, %v", get.GetValue(), err)
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("permission denied", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.ACL.File = filepath.Join(t.TempDir(), "acl.yaml")
+		policy := "rules:\n  - principal: \"*\"\n    operations: [get, put]\n    keys: [\"7\"]\n"
+		if err := os.WriteFile(cfg.ACL.File, []byte(policy), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		c := dialGRPC(t, newTestServer(t, cfg))
+
+		if _, err := c.Put(ctx, &kvpb.PutRequest{Key: 7, Value: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Put(ctx, &kvpb.PutRequest{Key: 8, Value: "a"})
+		wantCode(t, "put outside the policy", err, codes.PermissionDenied)
+		_, err = c.Delete(ctx, &kvpb.DeleteRequest{Key: 7})
+		wantCode(t, "delete outside the policy", err, codes.PermissionDenied)
+		_, err = c.BatchGet(ctx, &kvpb.BatchGetRequest{Keys: []int64{7, 8}})
+		wantCode(t, "batch get with a key outside the policy", err, codes.PermissionDenied)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		st, _ := openBreaker(t)
+		s, err := New(testConfig(), st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := dialGRPC(t, s)
+
+		_, err = c.Get(ctx, &kvpb.GetRequest{Key: 1})
+		wantCode(t, "get with the breaker open", err, codes.Unavailable)
+		_, err = c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "a"})
+		wantCode(t, "put with the breaker open", err, codes.Unavailable)
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Server.EndpointTimeouts = map[string]time.Duration{"/get": 50 * time.Millisecond}
+		s, err := New(cfg, &stalledStore{store.NewMemory()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := dialGRPC(t, s)
+
+		start := time.Now()
+		_, err = c.Get(ctx, &kvpb.GetRequest{Key: 1})
+		wantCode(t, "get from a stalled store", err, codes.DeadlineExceeded)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("get took %v, want about the 50ms endpoint timeout", elapsed)
+		}
+	})
+
+	t.Run("invalid argument", func(t *testing.T) {
+		c := dialGRPC(t, newTestServer(t, testConfig()))
+
+		_, err := c.Scan(ctx, &kvpb.ScanRequest{Limit: -1})
+		wantCode(t, "scan with a negative limit", err, codes.InvalidArgument)
+		_, err = c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: strings.Repeat("x", testConfig().Server.MaxValueBytes+1)})
+		wantCode(t, "put of an oversized value", err, codes.InvalidArgument)
+	})
+}
+
+func TestGRPCBatchGet(t *testing.T) {
+	c := dialGRPC(t, newTestServer(t, testConfig()))
+	ctx := context.Background()
+	for _, key := range []int64{1, 3} {
+		if _, err := c.Put(ctx, &kvpb.PutRequest{Key: key, Value: "v" + strings.Repeat("!", int(key))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := c.BatchGet(ctx, &kvpb.BatchGetRequest{Keys: []int64{3, 2, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		key   int64
+		found bool
+		value string
+	}{
+		{3, true, "v!!!"},
+		{2, false, ""},
+		{1, true, "v!"},
+	}
+	if len(resp.Items) != len(want) {
+		t.Fatalf("%d items, want %d", len(resp.Items), len(want))
+	}
+	for i, w := range want {
+		item := resp.Items[i]
+		if item.Key != w.key || item.Found != w.found || item.Value != w.value {
+			t.Errorf("item %d: got %v, want %+v", i, item, w)
+		}
+	}
+}
+
+func TestGRPCScanPages(t *testing.T) {
+	c := dialGRPC(t, newTestServer(t, testConfig()))
+	ctx := context.Background()
+	for key := range int64(5) {
+		if _, err := c.Put(ctx, &kvpb.PutRequest{Key: key + 1, Value: "v"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		keys  []int64
+		pages int
+		req   = &kvpb.ScanRequest{Limit: 2}
+	)
+	for {
+		resp, err := c.Scan(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, item := range resp.Items {
+			if !item.Found || item.Value != "v" {
+				t.Errorf("item %v, want the value", item)
+			}
+			keys = append(keys, item.Key)
+		}
+		if !resp.More {
+			break
+		}
+		if resp.NextKey != keys[len(keys)-1]+1 {
+			t.Fatalf("next key %d after key %d", resp.NextKey, keys[len(keys)-1])
+		}
+		req.StartKey = resp.NextKey
+	}
+	if pages != 3 || len(keys) != 5 {
+		t.Fatalf("got keys %v in %d pages, want 1 to 5 in 3", keys, pages)
+	}
+	for i, key := range keys {
+		if key != int64(i+1) {
+			t.Fatalf("got keys %v, want 1 to 5 in order", keys)
+		}
+	}
+
+	resp, err := c.Scan(ctx, &kvpb.ScanRequest{StartKey: 4, KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.More || len(resp.Items) != 2 || resp.Items[0].Value != "" {
+		t.Fatalf("keys only scan from 4: got %v", resp)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	c := dialGRPC(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := c.Watch(ctx, &kvpb.WatchRequest{Keys: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server sends headers once it has subscribed.
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, &kvpb.PutRequest{Key: 1, Value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, &kvpb.PutRequest{Key: 2, Value: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Delete(ctx, &kvpb.DeleteRequest{Key: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Key 2 is not watched.
+	for _, want := range []*kvpb.WatchEvent{
+		{Type: kvpb.WatchEvent_PUT, Key: 1, Value: "a", Version: 1},
+		{Type: kvpb.WatchEvent_DELETE, Key: 1, Version: 1},
+	} {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want.Type || ev.Key != want.Key || ev.Value != want.Value || ev.Version != want.Version {
+			t.Errorf("got %v, want %v", ev, want)
+		}
+	}
+}
+
+func TestGRPCWatchDropsSlowWatcher(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	// A fixed window turns off the client's window growth, so an unread
+	// stream stops the server's sends once about 64KiB is outstanding.
+	c := dialGRPC(t, s, grpc.WithInitialWindowSize(64<<10), grpc.WithInitialConnWindowSize(64<<10))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := c.Watch(ctx, &kvpb.WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	// Far more than the window, the server's write buffers and
+	// watchBuffer hold together.
+	const puts = 1000
+	value := strings.Repeat("x", 1<<10)
+	for i := range puts {
+		if _, err := s.putValue(ctx, i+1, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := 0
+	for {
+		_, err := stream.Recv()
+		if err != nil {
+			wantCode(t, "watch stream of a slow client", err, codes.ResourceExhausted)
+			break
+		}
+		received++
+	}
+	if received >= puts {
+		t.Errorf("received all %d changes, want the stream dropped", received)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"decsproject/breaker"
+	"decsproject/quota"
+	"decsproject/store"
+)
+
+type keyValue struct {
+	Key   int    `json:"key"`
+	Value string `json:"value"`
+}
+
+func (s *Server) hello(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(w, "hello")
+}
+
+func (s *Server) put(w http.ResponseWriter, req *http.Request) {
+	receivedData, ok := s.readKeyValue(w, req, true)
+	if !ok {
+		return
+	}
+	info(req.Context()).key = strconv.Itoa(receivedData.Key)
+
+	if _, err := s.putValue(req.Context(), receivedData.Key, receivedData.Value); err != nil {
+		s.opFailed(w, req, err, "Database EXEC error (put/upsert)", "Failed to execute upsert query")
+		return
+	}
+
+	fmt.Fprintf(w, "Key %d value %s created/updated", receivedData.Key, receivedData.Value)
+}
+
+func (s *Server) get(w http.ResponseWriter, req *http.Request) {
+	key, ok := s.getKey(w, req)
+	if !ok {
+		return
+	}
+	ri := info(req.Context())
+	ri.key = strconv.Itoa(key)
+
+	value, cached, err := s.getValue(req.Context(), key)
+	if cached {
+		ri.cache = "hit"
+		if s.degraded() && s.cfg.Storage.Breaker.StaleReads {
+			// The store cannot be asked whether the value is current.
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+		}
+		fmt.Fprintf(w, "The value for key %d is %s (from cache)", key, value)
+		return
+	}
+	if err == nil || err == store.ErrNotFound {
+		ri.cache = "miss"
+	}
+
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Key %d is not present", key)
+		return
+	}
+	if err != nil {
+		s.opFailed(w, req, err, "Database QueryRow error (get)", "Failed to execute query")
+		return
+	}
+
+	fmt.Fprintf(w, "The value for key %d is %s (from DB)", key, value)
+}
+
+func (s *Server) del(w http.ResponseWriter, req *http.Request) {
+	toDelete, ok := s.readKeyValue(w, req, false)
+	if !ok {
+		return
+	}
+	info(req.Context()).key = strconv.Itoa(toDelete.Key)
+
+	_, err := s.deleteValue(req.Context(), toDelete.Key)
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Key %d is not present", toDelete.Key)
+		return
+	}
+	if err != nil {
+		s.opFailed(w, req, err, "Database EXEC error (delete)", "Failed to execute delete query")
+		return
+	}
+
+	fmt.Fprintf(w, "Key-Value pair for key %d has been deleted", toDelete.Key)
+}
+
+// opFailed logs a failed key value operation and writes its response: 403
+// if the ACL or a quota refused it, 503 if the circuit breaker is open or
+// the client went away, 504 if the request's deadline expired while
+// waiting on storage, and 500 with msg otherwise.
+func (s *Server) opFailed(w http.ResponseWriter, req *http.Request, err error, logMsg, msg string) {
+	key := info(req.Context()).key
+	var denied *deniedError
+	switch {
+	case errors.As(err, &denied), errors.Is(err, quota.ErrExceeded):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, store.ErrUnavailable):
+		s.unavailable(w)
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(req.Context(), logMsg, "key", key, "err", err)
+		http.Error(w, "Storage did not respond before the request deadline", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		http.Error(w, "Request cancelled", http.StatusServiceUnavailable)
+	default:
+		slog.ErrorContext(req.Context(), logMsg, "key", key, "err", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+// degraded reports whether the storage circuit breaker is open. Once it is
+// half open requests go to the store again so one can probe it.
+func (s *Server) degraded() bool {
+	return s.breaker != nil && s.breaker.State() == breaker.Open
+}
+
+// unavailable answers a request that needs the store while the circuit
+// breaker is open.
+func (s *Server) unavailable(w http.ResponseWriter) {
+	if s.breaker != nil {
+		wait := max(math.Ceil(s.breaker.RetryAfter().Seconds()), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait)))
+	}
+	http.Error(w, "Storage unavailable, try again later", http.StatusServiceUnavailable)
+}
+
+// getKey reads the /get key from the JSON body or the ?key= query
+// parameter, depending on the server.get_key setting.
+func (s *Server) getKey(w http.ResponseWriter, req *http.Request) (int, bool) {
+	source := s.cfg.Server.GetKey
+	if source == "auto" {
+		source = "body"
+		if req.URL.Query().Has("key") {
+			source = "query"
+		}
+	}
+
+	if source == "body" {
+		toSend, ok := s.readKeyValue(w, req, false)
+		return toSend.Key, ok
+	}
+
+	keyStr := req.URL.Query().Get("key")
+	if keyStr == "" {
+		http.Error(w, "Missing 'key' parameter in URL query", http.StatusBadRequest)
+		return 0, false
+	}
+	if !s.checkKeySize(w, keyStr) {
+		return 0, false
+	}
+
+	key, err := strconv.Atoi(keyStr)
+	if err != nil {
+		http.Error(w, "Invalid key format", http.StatusBadRequest)
+		return 0, false
+	}
+	return key, true
+}
+
+// keyRequest is the JSON body of /put, /get and /delete. The pointers tell
+// a missing field from a zero one.
+type keyRequest struct {
+	Key   *int    `json:"key"`
+	Value *string `json:"value"`
+}
+
+// readKeyValue decodes and checks a key value request body. withValue
+// requires a non-empty value, as /put does; /get and /delete ignore any
+// value sent.
+func (s *Server) readKeyValue(w http.ResponseWriter, req *http.Request, withValue bool) (keyValue, bool) {
+	var in keyRequest
+	if !s.readJSON(w, req, &in) {
+		return keyValue{}, false
+	}
+
+	if in.Key == nil {
+		http.Error(w, "Missing 'key' field", http.StatusBadRequest)
+		return keyValue{}, false
+	}
+	if !s.checkKeySize(w, strconv.Itoa(*in.Key)) {
+		return keyValue{}, false
+	}
+	kv := keyValue{Key: *in.Key}
+
+	if withValue {
+		if in.Value == nil || *in.Value == "" {
+			http.Error(w, "Missing or empty 'value' field", http.StatusBadRequest)
+			return keyValue{}, false
+		}
+		if limit := s.cfg.Server.MaxValueBytes; len(*in.Value) > limit {
+			http.Error(w, fmt.Sprintf("Value exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return keyValue{}, false
+		}
+		kv.Value = *in.Value
+	}
+	return kv, true
+}
+
+func (s *Server) checkKeySize(w http.ResponseWriter, key string) bool {
+	if limit := s.cfg.Server.MaxKeyBytes; len(key) > limit {
+		http.Error(w, fmt.Sprintf("Key exceeds %d bytes", limit), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// readJSON strictly decodes a single JSON object of at most
+// server.max_body_bytes from the request body into v, writing an error
+// response and returning false if it cannot.
+func (s *Server) readJSON(w http.ResponseWriter, req *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, int64(s.cfg.Server.MaxBodyBytes)))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		// Anything after the object, even a second object, is rejected.
+		if _, err = dec.Token(); err == io.EOF {
+			return true
+		} else if err == nil {
+			err = errors.New("unexpected data after JSON object")
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case err == io.EOF:
+		http.Error(w, "Empty request body", http.StatusBadRequest)
+	default:
+		http.Error(w, "Invalid JSON format: "+err.Error(), http.StatusBadRequest)
+	}
+	return false
+}
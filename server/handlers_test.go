@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetServesCacheHitsWhileBreakerOpen(t *testing.T) {
+	for _, staleReads := range []bool{true, false} {
+		st, _ := openBreaker(t)
+		cfg := testConfig()
+		cfg.Storage.Breaker.StaleReads = staleReads
+		s, err := New(cfg, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.cache.Put("1", "one")
+
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/get?key=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stale_reads %v: cache hit got %d %s, want 200", staleReads, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Warning") != ""; got != staleReads {
+			t.Errorf("stale_reads %v: Warning header %q", staleReads, rec.Header().Get("Warning"))
+		}
+
+		rec = httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/get?key=2", nil))
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("stale_reads %v: cache miss got %d, want 503 with Retry-After", staleReads, rec.Code)
+		}
+	}
+}
+
+func TestPutRejectsBadBodies(t *testing.T) {
+	cfg := testConfig()
+	cfg.Server.MaxBodyBytes = 64
+	cfg.Server.MaxKeyBytes = 4
+	cfg.Server.MaxValueBytes = 8
+	h := newTestServer(t, cfg).Handler()
+
+	for _, tc := range []struct {
+		name, path, body string
+		want             int
+	}{
+		{"ok", "/put", `{"key":1,"value":"a"}`, http.StatusOK},
+		{"body too large", "/put", `{"key":1,"value":"` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge},
+		{"value too large", "/put", `{"key":1,"value":"123456789"}`, http.StatusRequestEntityTooLarge},
+		{"key too large", "/put", `{"key":12345,"value":"a"}`, http.StatusBadRequest},
+		{"negative key too large", "/delete", `{"key":-1234}`, http.StatusBadRequest},
+		{"key at the limit", "/put", `{"key":1234,"value":"a"}`, http.StatusOK},
+		{"unknown field", "/put", `{"key":1,"value":"a","ttl":5}`, http.StatusBadRequest},
+		{"trailing object", "/put", `{"key":1,"value":"a"}{"key":2,"value":"b"}`, http.StatusBadRequest},
+		{"trailing garbage", "/put", `{"key":1,"value":"a"} x`, http.StatusBadRequest},
+		{"trailing whitespace", "/put", "{\"key\":1,\"value\":\"a\"}\n", http.StatusOK},
+		{"empty body", "/put", ``, http.StatusBadRequest},
+		{"missing key", "/put", `{"value":"a"}`, http.StatusBadRequest},
+		{"empty value", "/put", `{"key":1,"value":""}`, http.StatusBadRequest},
+		{"key not a number", "/put", `{"key":"1","value":"a"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d %q, want %d", tc.name, rec.Code, rec.Body.String(), tc.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/get?key=123456", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("get with a long key: got %d, want 400", rec.Code)
+	}
+}
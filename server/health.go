@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"decsproject/breaker"
+)
+
+// readyTimeout bounds the storage ping made by /readyz.
+const readyTimeout = 2 * time.Second
+
+type check struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Latency  string `json:"latency,omitempty"`
+	Size     *int   `json:"size,omitempty"`
+	Capacity *int   `json:"capacity,omitempty"`
+}
+
+type healthReport struct {
+	Status string           `json:"status"`
+	Checks map[string]check `json:"checks,omitempty"`
+}
+
+// healthz reports that the process is alive and able to serve HTTP.
+func (s *Server) healthz(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, healthReport{Status: "ok"})
+}
+
+// readyz reports whether this instance should receive traffic: the store
+// must answer a ping, warm-up must have finished and the server must not
+// be shutting down. The storage circuit breaker only shows in the body.
+func (s *Server) readyz(w http.ResponseWriter, req *http.Request) {
+	report := healthReport{Status: "ok", Checks: map[string]check{}}
+	fail := func(name string, c check) {
+		report.Checks[name] = c
+		report.Status = "unavailable"
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := s.store.Ping(ctx); err != nil {
+		fail("storage", check{Status: "error", Error: err.Error()})
+	} else {
+		report.Checks["storage"] = check{Status: "ok", Latency: time.Since(start).String()}
+	}
+
+	size, capacity := s.cache.Len(), s.cache.Capacity()
+	report.Checks["cache"] = check{Status: "ok", Size: &size, Capacity: &capacity}
+
+	if s.warmedUp.Load() {
+		report.Checks["warmup"] = check{Status: "ok"}
+	} else {
+		fail("warmup", check{Status: "pending"})
+	}
+
+	if s.shuttingDown.Load() {
+		fail("shutdown", check{Status: "draining"})
+	}
+
+	// A breaker that is not closed marks the instance degraded but keeps
+	// it ready: it still serves cache hits, and only live traffic can
+	// close the breaker again, so pulling the instance would keep it open.
+	if s.breaker != nil {
+		state := s.breaker.State()
+		report.Checks["breaker"] = check{Status: state.String()}
+		if state != breaker.Closed && report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+
+	writeHealth(w, report)
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == "unavailable" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"decsproject/breaker"
+	"decsproject/store"
+)
+
+// openBreaker returns a memory store behind a circuit breaker that has
+// already tripped.
+func openBreaker(t *testing.T) (store.Store, *breaker.Breaker) {
+	t.Helper()
+	b := breaker.New(1, time.Hour)
+	st := store.Guard(store.NewMemory(), b)
+	b.Allow()
+	b.Record(false)
+	if b.State() != breaker.Open {
+		t.Fatalf("breaker %s, want open", b.State())
+	}
+	return st, b
+}
+
+func TestReadyzStaysReadyWhileBreakerOpen(t *testing.T) {
+	st, _ := openBreaker(t)
+	s, err := New(testConfig(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.warmedUp.Store(true)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	var report healthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "degraded" || report.Checks["breaker"].Status != breaker.Open.String() {
+		t.Fatalf("got %+v, want degraded with the breaker open", report)
+	}
+
+	s.shuttingDown.Store(true)
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("draining: got %d, want 503", rec.Code)
+	}
+}
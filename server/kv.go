@@ -0,0 +1,375 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"decsproject/acl"
+	"decsproject/audit"
+	"decsproject/auth"
+	"decsproject/quota"
+	"decsproject/store"
+	"decsproject/tracing"
+)
+
+// The operations below are the cache and storage path shared by every
+// protocol the server speaks. Each protocol authenticates its clients,
+// puts the principal in the context with auth.WithPrincipal and maps the
+// errors returned here onto its own status codes.
+
+// deniedError is returned when the principal lacks the scope for an
+// operation or the ACL does not allow it.
+type deniedError struct {
+	msg string
+}
+
+func (e *deniedError) Error() string { return e.msg }
+
+// badRequestError is returned for malformed requests.
+type badRequestError struct {
+	msg string
+}
+
+func (e *badRequestError) Error() string { return e.msg }
+
+// errUnauthenticated is returned for requests that need credentials the
+// client has not presented.
+var errUnauthenticated = errors.New("authentication required")
+
+// errShuttingDown ends long-lived requests, such as gRPC Watch streams,
+// when the server shuts down.
+var errShuttingDown = errors.New("server is shutting down")
+
+// limitError is returned for keys and values over the configured sizes.
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string { return e.msg }
+
+// rateLimitedError is returned when the rate limiter rejects a request.
+type rateLimitedError struct {
+	wait time.Duration
+}
+
+func (e *rateLimitedError) Error() string { return "rate limit exceeded" }
+
+// retryAfter is the whole number of seconds to tell a client to wait.
+func (e *rateLimitedError) retryAfter() int {
+	return int(math.Ceil(e.wait.Seconds()))
+}
+
+// principalName returns the authenticated client in ctx, or acl.Anonymous.
+func principalName(ctx context.Context) string {
+	if p := auth.FromContext(ctx); p != nil {
+		return p.Name
+	}
+	return acl.Anonymous
+}
+
+// allowed checks the ACL for op on key.
+func (s *Server) allowed(ctx context.Context, op, key string) error {
+	if !s.acl.Allowed(principalName(ctx), op, key) {
+		return &deniedError{op + " not allowed on key " + key}
+	}
+	return nil
+}
+
+// checkScope requires the principal in ctx to hold scope. Without
+// auth.enabled every request is let through.
+func (s *Server) checkScope(ctx context.Context, scope string) error {
+	if !s.cfg.Auth.Enabled {
+		return nil
+	}
+	p := auth.FromContext(ctx)
+	if p == nil {
+		return errUnauthenticated
+	}
+	if !p.HasScope(scope) {
+		return &deniedError{"missing '" + scope + "' scope"}
+	}
+	return nil
+}
+
+// limit applies the rate limiter to one request for endpoint, which names
+// the HTTP path of the equivalent operation so limits hold whichever
+// protocol a client uses. client is the principal when authenticated and
+// the remote IP otherwise.
+func (s *Server) limit(ctx context.Context, client, endpoint string) error {
+	if p := auth.FromContext(ctx); p != nil {
+		client = p.Name
+	}
+	if ok, wait := s.limiter.Allow(client, endpoint); !ok {
+		return &rateLimitedError{wait: wait}
+	}
+	return nil
+}
+
+// requestTimeout is the deadline for requests to endpoint: its
+// server.endpoint_timeouts entry or server.request_timeout.
+func (s *Server) requestTimeout(endpoint string) time.Duration {
+	if d, found := s.cfg.Server.EndpointTimeouts[endpoint]; found {
+		return d
+	}
+	return s.cfg.Server.RequestTimeout
+}
+
+// checkKey enforces server.max_key_bytes.
+func (s *Server) checkKey(key int) error {
+	if limit := s.cfg.Server.MaxKeyBytes; len(strconv.Itoa(key)) > limit {
+		return &limitError{fmt.Sprintf("key exceeds %d bytes", limit)}
+	}
+	return nil
+}
+
+// checkValue enforces server.max_value_bytes.
+func (s *Server) checkValue(value string) error {
+	if limit := s.cfg.Server.MaxValueBytes; len(value) > limit {
+		return &limitError{fmt.Sprintf("value exceeds %d bytes", limit)}
+	}
+	return nil
+}
+
+// getValue returns the value for key from the cache or, on a miss, from
+// the store, and whether it came from the cache. While the circuit breaker
+// is open cache hits are still served and misses fail fast.
+func (s *Server) getValue(ctx context.Context, key int) (string, bool, error) {
+	keyStr := strconv.Itoa(key)
+	if err := s.allowed(ctx, acl.OpGet, keyStr); err != nil {
+		return "", false, err
+	}
+
+	if s.expired(key) {
+		return "", false, store.ErrNotFound
+	}
+	_, span := tracing.Start(ctx, "cache.get", tracing.KindInternal)
+	value, found := s.cache.Get(keyStr)
+	span.SetAttr("kv.key", keyStr)
+	span.SetAttr("cache.hit", found)
+	span.End()
+	if found {
+		return value, true, nil
+	}
+
+	value, err := s.store.Get(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	s.cache.Put(keyStr, value)
+	return value, false, nil
+}
+
+// getVersioned returns the value for key with its version, for
+// compare-and-swap. The cache does not hold versions, so it always reads
+// the store, and refreshes the cache with what it read.
+func (s *Server) getVersioned(ctx context.Context, key int) (string, uint64, error) {
+	keyStr := strconv.Itoa(key)
+	if err := s.allowed(ctx, acl.OpGet, keyStr); err != nil {
+		return "", 0, err
+	}
+
+	if s.expired(key) {
+		return "", 0, store.ErrNotFound
+	}
+	if s.degraded() {
+		return "", 0, store.ErrUnavailable
+	}
+
+	value, version, err := store.GetVersion(ctx, s.store, key)
+	if err != nil {
+		return "", 0, err
+	}
+	s.cache.Put(keyStr, value)
+	return value, version, nil
+}
+
+// putValue stores value under key, within its quota, and returns the new
+// version. Like a Redis SET it clears any deadline on the key. Callers
+// check the key and value sizes first.
+func (s *Server) putValue(ctx context.Context, key int, value string) (uint64, error) {
+	return s.writeValue(ctx, key, value, func() (uint64, error) {
+		return s.store.Put(ctx, key, value)
+	})
+}
+
+// casValue is putValue for a key that must still be at version. It fails
+// with store.ErrVersionMismatch if the key was written since, or
+// store.ErrNotFound if it was deleted.
+func (s *Server) casValue(ctx context.Context, key int, value string, version uint64) (uint64, error) {
+	return s.writeValue(ctx, key, value, func() (uint64, error) {
+		return store.PutIfVersion(ctx, s.store, key, value, version)
+	})
+}
+
+func (s *Server) writeValue(ctx context.Context, key int, value string, put func() (uint64, error)) (uint64, error) {
+	keyStr := strconv.Itoa(key)
+	if err := s.allowed(ctx, acl.OpPut, keyStr); err != nil {
+		return 0, err
+	}
+
+	done, err := s.quota.Reserve(key, len(value))
+	if err != nil {
+		return 0, err
+	}
+
+	s.warming.wrote(key)
+	version, err := put()
+	done(err == nil)
+	if err != nil {
+		return 0, err
+	}
+	s.recordAudit(ctx, audit.OpPut, key, version-1, version)
+
+	s.expiry.clear(key)
+	s.cache.Put(keyStr, value)
+	s.feed.publish(change{key: key, value: value, version: version})
+	return version, nil
+}
+
+// deleteValue removes key and returns the version it had.
+func (s *Server) deleteValue(ctx context.Context, key int) (uint64, error) {
+	keyStr := strconv.Itoa(key)
+	if err := s.allowed(ctx, acl.OpDelete, keyStr); err != nil {
+		return 0, err
+	}
+
+	s.warming.wrote(key)
+	s.casTokens.forget(key)
+	version, err := s.store.Delete(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	s.casTokens.forget(key)
+
+	s.recordAudit(ctx, audit.OpDelete, key, version, 0)
+	s.quota.Remove(key)
+	s.expiry.clear(key)
+	s.cache.DeleteKey(keyStr)
+	s.feed.publish(change{deleted: true, key: key, version: version})
+	return version, nil
+}
+
+// lockStripes is how many mutexes serialise read-modify-write operations
+// on the same key.
+const lockStripes = 64
+
+// lock serialises a read-modify-write operation on key, such as INCR,
+// against others on the same key and against expiry. Plain puts and
+// deletes do not take it.
+func (s *Server) lock(key int) func() {
+	m := &s.locks[uint(key)%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
+
+// Outcomes reported by opResult, used as metric labels and mapped by each
+// protocol to its own status codes.
+const (
+	resultOK           = "ok"
+	resultNotFound     = "not_found"
+	resultBadRequest   = "bad_request"
+	resultUnauthorized = "unauthorized"
+	resultForbidden    = "forbidden"
+	resultTooLarge     = "too_large"
+	resultRateLimited  = "rate_limited"
+	resultConflict     = "conflict"
+	resultUnavailable  = "unavailable"
+	resultTimeout      = "timeout"
+	resultError        = "error"
+)
+
+// opResult classifies an error returned by the shared operations.
+func opResult(err error) string {
+	var (
+		denied  *deniedError
+		limited *rateLimitedError
+		size    *limitError
+		bad     *badRequestError
+	)
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, store.ErrNotFound):
+		return resultNotFound
+	case errors.As(err, &bad):
+		return resultBadRequest
+	case errors.Is(err, errUnauthenticated), errors.Is(err, auth.ErrNoCredentials), errors.Is(err, auth.ErrInvalidCredentials):
+		return resultUnauthorized
+	case errors.As(err, &denied), errors.Is(err, quota.ErrExceeded):
+		return resultForbidden
+	case errors.As(err, &size):
+		return resultTooLarge
+	case errors.As(err, &limited):
+		return resultRateLimited
+	case errors.Is(err, store.ErrVersionMismatch):
+		return resultConflict
+	case errors.Is(err, store.ErrUnavailable), errors.Is(err, errShuttingDown), errors.Is(err, context.Canceled):
+		return resultUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return resultTimeout
+	default:
+		return resultError
+	}
+}
+
+// observeOp finishes the span of a request served over a protocol other
+// than HTTP, records its metrics and writes its access log line, which
+// observe does for HTTP requests. keyStr is empty for requests without a
+// single key.
+func (s *Server) observeOp(ctx context.Context, span *tracing.Span, protocol, op, keyStr string, err error, start time.Time) {
+	elapsed := time.Since(start)
+	result := opResult(err)
+	s.metrics.ObserveProtocol(protocol, op, result, elapsed)
+
+	principal := ""
+	if p := auth.FromContext(ctx); p != nil {
+		principal = p.Name
+	}
+
+	span.SetAttr("rpc.system", protocol)
+	span.SetAttr("rpc.method", op)
+	span.SetAttr("kv.result", result)
+	if keyStr != "" {
+		span.SetAttr("kv.key", keyStr)
+	}
+	if principal != "" {
+		span.SetAttr("enduser.id", principal)
+	}
+	if result == resultError {
+		span.RecordError(err)
+	}
+	span.End()
+
+	level := slog.LevelInfo
+	if result == resultError {
+		level = slog.LevelError
+	} else if rate := s.cfg.Log.SampleRate; rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("protocol", protocol),
+		slog.String("op", op),
+		slog.String("result", result),
+		slog.Float64("latency_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if keyStr != "" {
+		attrs = append(attrs, slog.String("key", keyStr))
+	}
+	if principal != "" {
+		attrs = append(attrs, slog.String("principal", principal))
+	}
+	if result == resultError {
+		attrs = append(attrs, slog.String("err", err.Error()))
+	}
+	if sc := span.SpanContext(); sc.Sampled {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID.String()))
+	}
+	slog.LogAttrs(ctx, level, "request", attrs...)
+}
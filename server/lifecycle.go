@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"decsproject/store"
+)
+
+// ListenAndServe starts the admin, binary protocol, RESP, memcached and
+// gRPC listeners, if they are configured, and the expiry sweeper, then
+// listens on the public address and calls Serve. If an address cannot be
+// bound, whatever was already started is stopped and the store closed
+// before the error is returned.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.stopProtocols = cancel
+
+	if addr := s.cfg.Admin.ListenAddr; addr != "" {
+		al, err := net.Listen("tcp", addr)
+		if err != nil {
+			return s.abort(err)
+		}
+		admin := &http.Server{Handler: s.AdminHandler()}
+		go admin.Serve(al)
+		defer admin.Close()
+		slog.Info("Admin server running", "addr", al.Addr().String())
+	}
+	if addr := s.cfg.Binary.ListenAddr; addr != "" {
+		if err := s.listenProtocol(ctx, binaryProtocol, addr, s.newBinConn); err != nil {
+			return s.abort(err)
+		}
+	}
+	if addr := s.cfg.RESP.ListenAddr; addr != "" {
+		if err := s.listenProtocol(ctx, respProtocol, addr, s.newRESPConn); err != nil {
+			return s.abort(err)
+		}
+	}
+	if addr := s.cfg.Memcache.ListenAddr; addr != "" {
+		if err := s.listenProtocol(ctx, memcacheProtocol, addr, s.newMemcacheConn); err != nil {
+			return s.abort(err)
+		}
+	}
+	if addr := s.cfg.GRPC.ListenAddr; addr != "" {
+		if err := s.listenGRPC(ctx, addr); err != nil {
+			return s.abort(err)
+		}
+	}
+	s.protocols.Add(1)
+	go func() {
+		defer s.protocols.Done()
+		s.sweepExpired(ctx)
+	}()
+
+	if s.acl != nil {
+		go s.acl.Watch(ctx, s.cfg.ACL.ReloadInterval)
+	}
+	if s.certs != nil {
+		go s.certs.Watch(ctx, s.cfg.TLS.ReloadInterval)
+	}
+	if s.cfg.Storage.Encryption.Reencrypt && store.Encryption(s.store) != nil {
+		go s.reencrypt(ctx)
+	}
+
+	l, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return s.abort(err)
+	}
+	slog.Info("Server running", "addr", l.Addr().String(), "tls", s.tls != nil)
+	return s.Serve(ctx, l)
+}
+
+// abort stops whatever ListenAndServe started before err, waits for it and
+// closes the store, as shutting down would have.
+func (s *Server) abort(err error) error {
+	s.shuttingDown.Store(true)
+	s.stopProtocols()
+	s.protocols.Wait()
+	s.Close()
+	return err
+}
+
+// Serve handles requests on l, over TLS if it is configured, until ctx is
+// cancelled. It then stops accepting connections, waits up to
+// server.shutdown_timeout for in-flight requests to finish, forcibly closes
+// whatever is left, waits for the other protocol listeners to drain and
+// calls Close.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		TLSConfig:         s.tls,
+		ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       s.cfg.Server.ReadTimeout,
+		WriteTimeout:      s.cfg.Server.WriteTimeout,
+		IdleTimeout:       s.cfg.Server.IdleTimeout,
+		Protocols:         new(http.Protocols),
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: s.cfg.Server.HTTP2MaxConcurrentStreams},
+	}
+	srv.Protocols.SetHTTP1(true)
+	if s.cfg.Server.HTTP2 {
+		// ServeTLS offers h2 through ALPN; plaintext clients must speak
+		// h2c with prior knowledge, there is no Upgrade from HTTP/1.1.
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.tls != nil {
+			// The certificate comes from TLSConfig.GetCertificate.
+			serveErr <- srv.ServeTLS(l, "", "")
+			return
+		}
+		serveErr <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-serveErr:
+		// The other listeners run until their context is cancelled; stop
+		// them before the store goes away underneath them.
+		s.shuttingDown.Store(true)
+		if s.stopProtocols != nil {
+			s.stopProtocols()
+		}
+		s.protocols.Wait()
+		s.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	s.shuttingDown.Store(true)
+	slog.Info("Shutting down, draining in-flight requests", "timeout", s.cfg.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("Drain deadline exceeded, closing remaining connections", "err", err)
+		srv.Close()
+	}
+	if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) {
+		slog.Error("Server error during shutdown", "err", serveErr)
+	}
+	s.protocols.Wait()
+
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close flushes state that must survive a restart and releases the store.
+// It must only be called once no more requests are being served.
+func (s *Server) Close() error {
+	s.saveSnapshot()
+	if s.audit != nil {
+		if err := s.audit.Close(); err != nil {
+			slog.Error("Failed to close audit log", "err", err)
+		}
+	}
+	return s.store.Close()
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"decsproject/config"
+	"decsproject/store"
+)
+
+// slowStore holds every Get until release is closed and records when it
+// is closed.
+type slowStore struct {
+	*store.Memory
+	entered     chan struct{}
+	enteredOnce sync.Once
+	release     chan struct{}
+	closed      atomic.Bool
+}
+
+func (s *slowStore) Get(ctx context.Context, key int) (string, error) {
+	s.enteredOnce.Do(func() { close(s.entered) })
+	<-s.release
+	if s.closed.Load() {
+		return "", errors.New("store closed while a request was in flight")
+	}
+	return s.Memory.Get(ctx, key)
+}
+
+func (s *slowStore) Close() error {
+	s.closed.Store(true)
+	return s.Memory.Close()
+}
+
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Storage.Backend = "memory"
+	cfg.Admin.ListenAddr = ""
+	return &cfg
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	st := &slowStore{
+		Memory:  store.NewMemory(),
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	if _, err := st.Put(context.Background(), 1, "one"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(testConfig(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, l) }()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/get?key=1")
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		got <- result{resp.StatusCode, string(body), err}
+	}()
+
+	select {
+	case <-st.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the store")
+	}
+	cancel()
+
+	// Serve must keep waiting, with the store open, while the request is
+	// held.
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned with a request in flight: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if st.closed.Load() {
+		t.Fatal("store closed with a request in flight")
+	}
+
+	close(st.release)
+	r := <-got
+	if r.err != nil {
+		t.Fatalf("in-flight request failed: %v", r.err)
+	}
+	if r.status != http.StatusOK || !strings.Contains(r.body, "one") {
+		t.Fatalf("in-flight request: got %d %q, want 200 with the value", r.status, r.body)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the request finished")
+	}
+	if !st.closed.Load() {
+		t.Fatal("store not closed after Serve returned")
+	}
+}
+
+func TestListenAndServeCleansUpAfterBindFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	// Pick a free port for the binary listener, which binds first.
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryAddr := free.Addr().String()
+	free.Close()
+
+	st := &slowStore{Memory: store.NewMemory()}
+	cfg := testConfig()
+	cfg.Binary.ListenAddr = binaryAddr
+	cfg.RESP.ListenAddr = taken.Addr().String()
+	s, err := New(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ListenAndServe(context.Background()); err == nil {
+		t.Fatal("ListenAndServe succeeded on an address in use")
+	}
+	if !st.closed.Load() {
+		t.Error("store left open")
+	}
+	// The binary listener that did start was stopped.
+	if conn, err := net.Dial("tcp", binaryAddr); err == nil {
+		conn.Close()
+		t.Error("binary listener still accepting connections")
+	}
+}
+
+func TestServeSpeaksH2C(t *testing.T) {
+	h1 := new(http.Protocols)
+	h1.SetHTTP1(true)
+	h2c := new(http.Protocols)
+	h2c.SetUnencryptedHTTP2(true)
+
+	for _, tc := range []struct {
+		name      string
+		http2     bool
+		client    *http.Protocols
+		wantProto int // 0 if the request must fail
+	}{
+		{"h2c", true, h2c, 2},
+		{"http1 next to h2c", true, h1, 1},
+		{"h2c turned off", false, h2c, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Server.HTTP2 = tc.http2
+			s := newTestServer(t, cfg)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() { served <- s.Serve(ctx, l) }()
+			defer func() {
+				cancel()
+				if err := <-served; err != nil {
+					t.Errorf("Serve: %v", err)
+				}
+			}()
+
+			tr := &http.Transport{Protocols: tc.client}
+			defer tr.CloseIdleConnections()
+			c := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+			resp, err := c.Get("http://" + l.Addr().String() + "/healthz")
+			if tc.wantProto == 0 {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("request succeeded over %s", resp.Proto)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.ProtoMajor != tc.wantProto || resp.StatusCode != http.StatusOK {
+				t.Fatalf("got %d over %s, want 200 over HTTP/%d", resp.StatusCode, resp.Proto, tc.wantProto)
+			}
+		})
+	}
+}
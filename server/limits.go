@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"decsproject/store"
+)
+
+// admit wraps a key value handler with admission checks: the rate
+// limiter, with clients told apart by principal when authenticated and by
+// remote IP otherwise, and a 503 for requests whose deadline passed before
+// they got this far.
+func (s *Server) admit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Context().Err() != nil {
+			http.Error(w, "Request deadline exceeded before it could be served", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := s.limit(req.Context(), clientIP(req), req.URL.Path); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(err.(*rateLimitedError).retryAfter()))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// loadQuotaUsage counts the pairs already in the store against their
+// quotas. Backends without a query interface start empty, so there is
+// nothing to count.
+func (s *Server) loadQuotaUsage() error {
+	if s.quota == nil {
+		return nil
+	}
+
+	n := 0
+	err := store.Preload(context.Background(), s.store, store.SelectAll, func(key int, value string) {
+		s.quota.Seed(key, value)
+		n++
+	})
+	if err == store.ErrPreloadUnsupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Loaded quota usage", "keys", n)
+	return nil
+}
+
+// quotaUsage reports each namespace's keys and bytes against its quota.
+func (s *Server) quotaUsage(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, s.quota.Usage())
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"decsproject/config"
+	"decsproject/ratelimit"
+	"decsproject/store"
+)
+
+func TestRateLimitedRequestsGetRetryAfter(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit = ratelimit.Settings{Enabled: true, Default: ratelimit.Limit{Rate: 0.5, Burst: 1}}
+	h := newTestServer(t, cfg).Handler()
+
+	get := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/get?key=1", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("10.0.0.1:1000"); rec.Code != http.StatusNotFound {
+		t.Fatalf("first request: got %d, want 404", rec.Code)
+	}
+	rec := get("10.0.0.1:1001")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: got %d, want 429", rec.Code)
+	}
+	// A token takes two seconds at half a token per second.
+	if n, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || n < 1 || n > 2 {
+		t.Errorf("Retry-After %q, want 1 or 2 seconds", rec.Header().Get("Retry-After"))
+	}
+	// Clients are told apart by IP, not by port.
+	if rec := get("10.0.0.2:1000"); rec.Code != http.StatusNotFound {
+		t.Errorf("other client: got %d, want 404", rec.Code)
+	}
+}
+
+// failingStore fails every Put while fail is set.
+type failingStore struct {
+	*store.Memory
+	fail bool
+}
+
+func (f *failingStore) Put(ctx context.Context, key int, value string) (uint64, error) {
+	if f.fail {
+		return 0, errors.New("write failed")
+	}
+	return f.Memory.Put(ctx, key, value)
+}
+
+func TestFailedPutReleasesQuota(t *testing.T) {
+	cfg := testConfig()
+	cfg.Quotas = []config.Quota{{Namespace: "a", Keys: []string{"*"}, MaxKeys: 1}}
+	st := &failingStore{Memory: store.NewMemory(), fail: true}
+	s, err := New(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key int) int {
+		body := `{"key":` + strconv.Itoa(key) + `,"value":"v"}`
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/put", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := put(1); code == http.StatusOK {
+		t.Fatal("put succeeded against a failing store")
+	}
+	st.fail = false
+	// The failed put must not hold on to the namespace's only key.
+	if code := put(2); code != http.StatusOK {
+		t.Fatalf("put after a failed one: got %d, want 200", code)
+	}
+	if code := put(3); code != http.StatusForbidden {
+		t.Fatalf("put over quota: got %d, want 403", code)
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"decsproject/auth"
+	"decsproject/logging"
+)
+
+// protoConn is a connection of a protocol other than HTTP.
+type protoConn interface {
+	// serve handles requests until the client goes away or stopReading
+	// is called, then closes the connection.
+	serve()
+	// stopReading makes serve return once the requests already read
+	// have been answered.
+	stopReading()
+	close()
+}
+
+// listenProtocol starts serving a protocol other than HTTP on addr, over
+// TLS if it is configured. Serve waits for it to drain before closing the
+// store.
+func (s *Server) listenProtocol(ctx context.Context, name, addr string, newConn func(net.Conn) protoConn) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("Protocol server running", "protocol", name, "addr", l.Addr().String(), "tls", s.tls != nil)
+	if s.tls != nil {
+		l = tls.NewListener(l, s.tls)
+	}
+
+	s.protocols.Add(1)
+	go func() {
+		defer s.protocols.Done()
+		s.serveProtocol(ctx, name, l, newConn)
+	}()
+	return nil
+}
+
+// serveProtocol accepts connections on l until ctx is cancelled. It then
+// stops reading new requests, waits up to server.shutdown_timeout for
+// those already read to be answered and closes the connections.
+func (s *Server) serveProtocol(ctx context.Context, name string, l net.Listener, newConn func(net.Conn) protoConn) {
+	var (
+		mu    sync.Mutex
+		conns = make(map[protoConn]struct{})
+		wg    sync.WaitGroup
+	)
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Protocol listener failed", "protocol", name, "err", err)
+			}
+			break
+		}
+
+		c := newConn(conn)
+		mu.Lock()
+		conns[c] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serve()
+			mu.Lock()
+			delete(conns, c)
+			mu.Unlock()
+		}()
+	}
+
+	mu.Lock()
+	for c := range conns {
+		c.stopReading()
+	}
+	mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(s.cfg.Server.ShutdownTimeout):
+		slog.Warn("Drain deadline exceeded, closing remaining connections", "protocol", name)
+		mu.Lock()
+		for c := range conns {
+			c.close()
+		}
+		mu.Unlock()
+		<-drained
+	}
+}
+
+// streamConn is the connection handling shared by the protocols: idle
+// deadlines between requests and stopping reads for shutdown.
+type streamConn struct {
+	conn net.Conn
+	// client identifies unauthenticated connections to the rate limiter.
+	client string
+	idle   time.Duration
+
+	// principal is who the client authenticated as, if anyone.
+	principal atomic.Pointer[auth.Principal]
+
+	// mu orders read deadline changes against stopReading.
+	mu       sync.Mutex
+	stopping bool
+}
+
+func newStreamConn(conn net.Conn, idle time.Duration) streamConn {
+	client := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	return streamConn{conn: conn, client: client, idle: idle}
+}
+
+func (c *streamConn) stopReading() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopping = true
+	c.conn.SetReadDeadline(time.Now())
+}
+
+func (c *streamConn) close() {
+	c.conn.Close()
+}
+
+// awaitRequest gives the client the idle timeout to send its next
+// request, and reports false if the connection is being shut down.
+func (c *streamConn) awaitRequest() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping {
+		return false
+	}
+	var deadline time.Time
+	if c.idle > 0 {
+		deadline = time.Now().Add(c.idle)
+	}
+	c.conn.SetReadDeadline(deadline)
+	return true
+}
+
+// context returns the base context for a request on the connection. It is
+// not cancelled when the server shuts down, so requests already read are
+// answered.
+func (c *streamConn) context() context.Context {
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	if p := c.principal.Load(); p != nil {
+		ctx = auth.WithPrincipal(ctx, p)
+	}
+	return ctx
+}
+
+// quietReadError reports whether err is how connections normally end: the
+// client hanging up, or a deadline set by awaitRequest or stopReading.
+func quietReadError(err error) bool {
+	var ne net.Error
+	return err == io.EOF || errors.Is(err, net.ErrClosed) || (errors.As(err, &ne) && ne.Timeout())
+}
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"decsproject/acl"
+	"decsproject/auth"
+	"decsproject/memcache"
+	"decsproject/quota"
+	"decsproject/store"
+	"decsproject/tracing"
+)
+
+// memcacheProtocol labels memcached requests in metrics and logs.
+const memcacheProtocol = "memcache"
+
+// memcacheMaxRelative is the largest exptime memcached reads as seconds
+// from now; larger ones are Unix times.
+const memcacheMaxRelative = 30 * 24 * 60 * 60
+
+// mcCommand is one supported memcached command.
+type mcCommand struct {
+	// min and max bound the number of arguments, not counting noreply;
+	// max is -1 for no bound.
+	min, max int
+	// endpoint names the HTTP path whose rate limit, timeout and scope
+	// the command shares; empty for commands that touch no keys.
+	endpoint string
+	run      func(c *mcConn, ctx context.Context, cmd memcache.Command) (key string, err error)
+}
+
+var mcCommands = map[string]mcCommand{
+	"version": {0, 0, "", (*mcConn).version},
+	"get":     {1, -1, "/get", (*mcConn).get},
+	"gets":    {1, -1, "/get", (*mcConn).get},
+	"set":     {4, 4, "/put", (*mcConn).store},
+	"add":     {4, 4, "/put", (*mcConn).store},
+	"replace": {4, 4, "/put", (*mcConn).store},
+	"cas":     {5, 5, "/put", (*mcConn).store},
+	"incr":    {2, 2, "/put", (*mcConn).incr},
+	"decr":    {2, 2, "/put", (*mcConn).incr},
+	"touch":   {2, 2, "/put", (*mcConn).touch},
+	"delete":  {1, 2, "/delete", (*mcConn).del},
+}
+
+var errMCFormat = &badRequestError{"bad command line format"}
+
+// errMCFlags rejects items with flags, which clients use to mark
+// serialised or compressed values; they are not stored, so such a value
+// would read back as something else.
+var errMCFlags = &badRequestError{"flags are not supported, only 0"}
+
+// mcConn is one memcached connection. Commands are answered in order;
+// replies are flushed once no more pipelined commands are buffered.
+type mcConn struct {
+	streamConn
+	s *Server
+	r *memcache.Reader
+	w *memcache.Writer
+
+	// noreply is set while running a command sent with noreply, whose
+	// reply is dropped unless it is an error.
+	noreply bool
+}
+
+func (s *Server) newMemcacheConn(conn net.Conn) protoConn {
+	return &mcConn{
+		streamConn: newStreamConn(conn, s.cfg.Server.IdleTimeout),
+		s:          s,
+		r:          memcache.NewReader(conn, s.cfg.Server.MaxBodyBytes),
+		w:          memcache.NewWriter(conn),
+	}
+}
+
+func (c *mcConn) serve() {
+	defer c.conn.Close()
+
+	for c.awaitRequest() {
+		cmd, err := c.r.ReadCommand()
+		switch {
+		case err == memcache.ErrTooLarge:
+			c.w.ServerError("object too large for cache")
+		case errors.Is(err, memcache.ErrProtocol):
+			c.w.ClientError(strings.TrimPrefix(err.Error(), memcache.ErrProtocol.Error()+": "))
+			c.flush()
+			return
+		case err != nil:
+			if !quietReadError(err) {
+				slog.Debug("Memcached protocol read failed", "remote", c.client, "err", err)
+			}
+			return
+		case cmd.Name == "quit":
+			c.flush()
+			return
+		default:
+			c.dispatch(cmd)
+		}
+		if c.r.Buffered() == 0 && c.flush() != nil {
+			return
+		}
+	}
+}
+
+func (c *mcConn) flush() error {
+	if d := c.s.cfg.Server.WriteTimeout; d > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	return c.w.Flush()
+}
+
+// reply writes a one-line reply unless the command asked for noreply.
+func (c *mcConn) reply(line string) {
+	if !c.noreply {
+		c.w.Line(line)
+	}
+}
+
+// dispatch runs one command and writes its reply.
+func (c *mcConn) dispatch(cmd memcache.Command) {
+	spec, found := mcCommands[cmd.Name]
+	if !found {
+		c.w.Error()
+		return
+	}
+	c.noreply = false
+	if n := len(cmd.Args); n > 0 && cmd.Name != "get" && cmd.Name != "gets" && cmd.Args[n-1] == "noreply" {
+		c.noreply = true
+		cmd.Args = cmd.Args[:n-1]
+	}
+
+	ctx, span := tracing.Start(c.context(), "memcache "+cmd.Name, tracing.KindServer)
+	start := time.Now()
+
+	var (
+		key string
+		err error
+	)
+	switch {
+	case len(cmd.Args) < spec.min, spec.max >= 0 && len(cmd.Args) > spec.max:
+		err = errMCFormat
+	case c.s.cfg.Auth.Enabled && c.principal.Load() == nil && cmd.Name == "set":
+		// memcached's text protocol authentication: the first set on a
+		// connection carries "username password" as its data.
+		err = c.login(cmd.Data)
+	case spec.endpoint == "":
+		key, err = spec.run(c, ctx, cmd)
+	default:
+		scope := auth.ScopeWrite
+		if spec.endpoint == "/get" {
+			scope = auth.ScopeRead
+		}
+		if err = c.s.checkScope(ctx, scope); err != nil {
+			break
+		}
+		opCtx, cancel := context.WithTimeout(ctx, c.s.requestTimeout(spec.endpoint))
+		key, err = spec.run(c, opCtx, cmd)
+		cancel()
+	}
+	c.s.observeOp(ctx, span, memcacheProtocol, cmd.Name, key, err, start)
+
+	if err != nil {
+		c.writeError(err)
+	}
+}
+
+// writeError sends the error reply for an error from a command.
+func (c *mcConn) writeError(err error) {
+	var limited *rateLimitedError
+	switch opResult(err) {
+	case resultBadRequest:
+		c.w.ClientError(err.Error())
+	case resultUnauthorized:
+		if errors.Is(err, errUnauthenticated) {
+			c.w.ClientError("unauthenticated")
+		} else {
+			c.w.ClientError("authentication failure")
+		}
+	case resultForbidden:
+		if errors.Is(err, quota.ErrExceeded) {
+			c.w.ServerError("out of memory storing object: " + err.Error())
+		} else {
+			c.w.ClientError("permission denied: " + err.Error())
+		}
+	case resultTooLarge:
+		c.w.ServerError(err.Error())
+	case resultRateLimited:
+		errors.As(err, &limited)
+		c.w.ServerError("rate limit exceeded, retry after " + strconv.Itoa(limited.retryAfter()) + "s")
+	case resultUnavailable:
+		c.w.ServerError("storage unavailable, try again later")
+	case resultTimeout:
+		c.w.ServerError("request deadline exceeded")
+	default:
+		c.w.ServerError("internal error")
+	}
+}
+
+// login authenticates the connection with the password in data, tried as
+// an API key and then as a bearer token. The username is ignored.
+func (c *mcConn) login(data string) error {
+	_, password, found := strings.Cut(data, " ")
+	if !found {
+		return auth.ErrInvalidCredentials
+	}
+	p, err := c.s.auth.AuthenticateKey(password)
+	if err != nil {
+		p, err = c.s.auth.AuthenticateToken(password)
+	}
+	if err != nil {
+		return err
+	}
+	c.principal.Store(p)
+	c.reply("STORED")
+	return nil
+}
+
+// key parses a key argument and applies the rate limit for endpoint and
+// the key size limit to it.
+func (c *mcConn) key(ctx context.Context, arg, endpoint string) (int, error) {
+	key, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, &badRequestError{"key must be an integer"}
+	}
+	if err := c.s.limit(ctx, c.client, endpoint); err != nil {
+		return 0, err
+	}
+	return key, c.s.checkKey(key)
+}
+
+// mcDeadline converts an exptime: 0 means none, up to 30 days is seconds
+// from now and anything larger a Unix time. Negative ones have passed.
+func mcDeadline(exptime int64) (time.Time, bool) {
+	switch {
+	case exptime == 0:
+		return time.Time{}, false
+	case exptime < 0:
+		return time.Now(), true
+	case exptime <= memcacheMaxRelative:
+		return time.Now().Add(time.Duration(exptime) * time.Second), true
+	default:
+		return time.Unix(exptime, 0), true
+	}
+}
+
+func (c *mcConn) version(ctx context.Context, cmd memcache.Command) (string, error) {
+	c.w.Line("VERSION 1.0.0")
+	return "", nil
+}
+
+// casTokens issues the CAS tokens gets returns. Versions restart at 1
+// when a key is deleted and created again, so a version alone would let a
+// cas made across a delete succeed. A token instead comes from a counter
+// that never repeats, seeded from the clock so it does not across restarts
+// either, and stands for one version of the key until the key is deleted.
+// Tokens from another server, or from before a restart, are unknown and
+// answered with EXISTS.
+type casTokens struct {
+	mu     sync.Mutex
+	last   uint64
+	tokens map[int]casToken
+	// deletes counts deletions per lock stripe, so a token read before
+	// a delete that finishes while it is being issued is not kept.
+	deletes [lockStripes]uint64
+}
+
+type casToken struct {
+	token, version uint64
+}
+
+// deletions returns the count of deletions in key's stripe, to be passed
+// to issue once key has been read.
+func (t *casTokens) deletions(key int) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.deletes[uint(key)%lockStripes]
+}
+
+// issue returns the token for key at version, which was read when key's
+// stripe had seen deletions deletions.
+func (t *casTokens) issue(key int, version, deletions uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ct, ok := t.tokens[key]; ok && ct.version == version {
+		return ct.token
+	}
+	if t.last == 0 {
+		t.last = uint64(time.Now().UnixNano())
+	}
+	t.last++
+	if t.deletes[uint(key)%lockStripes] != deletions {
+		// The version may belong to a key since deleted; a token that is
+		// not kept matches no later cas.
+		return t.last
+	}
+	if t.tokens == nil {
+		t.tokens = make(map[int]casToken)
+	}
+	t.tokens[key] = casToken{t.last, version}
+	return t.last
+}
+
+// version returns the version token was issued for, if it is key's
+// current token.
+func (t *casTokens) version(key int, token uint64) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ct, ok := t.tokens[key]
+	return ct.version, ok && ct.token == token
+}
+
+// forget drops key's token. Deletes call it both before and after
+// removing the key, so a token issued while the delete runs goes too.
+func (t *casTokens) forget(key int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tokens, key)
+	t.deletes[uint(key)%lockStripes]++
+}
+
+// get answers get and gets; gets adds a CAS token for each key. store
+// only accepts flags 0, so that is what items carry.
+func (c *mcConn) get(ctx context.Context, cmd memcache.Command) (string, error) {
+	type item struct {
+		key, value string
+		token      uint64
+	}
+	withCAS := cmd.Name == "gets"
+	items := make([]item, 0, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		key, err := c.key(ctx, arg, "/get")
+		if err != nil {
+			return "", err
+		}
+		var (
+			value string
+			token uint64
+		)
+		if withCAS {
+			deletions := c.s.casTokens.deletions(key)
+			var version uint64
+			if value, version, err = c.s.getVersioned(ctx, key); err == nil {
+				token = c.s.casTokens.issue(key, version, deletions)
+			}
+		} else {
+			value, _, err = c.s.getValue(ctx, key)
+		}
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item{arg, value, token})
+	}
+
+	for _, it := range items {
+		c.w.Value(it.key, 0, it.value, it.token, withCAS)
+	}
+	c.w.End()
+	if len(cmd.Args) == 1 {
+		return cmd.Args[0], nil
+	}
+	return "", nil
+}
+
+// store answers set, add, replace and cas. add and replace check whether
+// the key exists under its lock, so they hold against other memcached
+// and RESP commands but not against plain puts from other protocols; cas
+// is checked by the store and holds against every write.
+func (c *mcConn) store(ctx context.Context, cmd memcache.Command) (string, error) {
+	keyArg := cmd.Args[0]
+	flags, err := strconv.ParseUint(cmd.Args[1], 10, 32)
+	if err != nil {
+		return keyArg, errMCFormat
+	}
+	if flags != 0 {
+		return keyArg, errMCFlags
+	}
+	exptime, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+	if err != nil {
+		return keyArg, errMCFormat
+	}
+	var casToken uint64
+	if cmd.Name == "cas" {
+		if casToken, err = strconv.ParseUint(cmd.Args[4], 10, 64); err != nil {
+			return keyArg, errMCFormat
+		}
+	}
+
+	key, err := c.key(ctx, keyArg, "/put")
+	if err != nil {
+		return keyArg, err
+	}
+	if cmd.Data == "" {
+		return keyArg, &badRequestError{"empty values are not supported"}
+	}
+	if err := c.s.checkValue(cmd.Data); err != nil {
+		return keyArg, err
+	}
+
+	unlock := c.s.lock(key)
+	defer unlock()
+
+	switch cmd.Name {
+	case "add", "replace":
+		_, _, err := c.s.getValue(ctx, key)
+		if err != nil && err != store.ErrNotFound {
+			return keyArg, err
+		}
+		if exists := err == nil; exists == (cmd.Name == "add") {
+			c.reply("NOT_STORED")
+			return keyArg, nil
+		}
+		_, err = c.s.putValue(ctx, key, cmd.Data)
+		if err != nil {
+			return keyArg, err
+		}
+	case "cas":
+		if c.s.expired(key) {
+			c.reply("NOT_FOUND")
+			return keyArg, nil
+		}
+		version, ok := c.s.casTokens.version(key, casToken)
+		if !ok {
+			// Not the token of the key as it is now; tell a missing key
+			// from a changed one.
+			_, _, err := c.s.getValue(ctx, key)
+			switch err {
+			case nil:
+				c.reply("EXISTS")
+				return keyArg, nil
+			case store.ErrNotFound:
+				c.reply("NOT_FOUND")
+				return keyArg, nil
+			default:
+				return keyArg, err
+			}
+		}
+		_, err := c.s.casValue(ctx, key, cmd.Data, version)
+		switch err {
+		case nil:
+		case store.ErrVersionMismatch:
+			c.reply("EXISTS")
+			return keyArg, nil
+		case store.ErrNotFound:
+			c.reply("NOT_FOUND")
+			return keyArg, nil
+		default:
+			return keyArg, err
+		}
+	default:
+		if _, err := c.s.putValue(ctx, key, cmd.Data); err != nil {
+			return keyArg, err
+		}
+	}
+
+	if at, ok := mcDeadline(exptime); ok {
+		c.s.expiry.set(key, at)
+	}
+	c.reply("STORED")
+	return keyArg, nil
+}
+
+// incr answers incr and decr on the unsigned 64-bit decimal stored at key.
+// incr wraps around and decr stops at 0, as in memcached. The key keeps
+// any deadline it has.
+func (c *mcConn) incr(ctx context.Context, cmd memcache.Command) (string, error) {
+	keyArg := cmd.Args[0]
+	delta, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+	if err != nil {
+		return keyArg, &badRequestError{"invalid numeric delta argument"}
+	}
+	key, err := c.key(ctx, keyArg, "/put")
+	if err != nil {
+		return keyArg, err
+	}
+
+	unlock := c.s.lock(key)
+	defer unlock()
+
+	value, _, err := c.s.getValue(ctx, key)
+	if err == store.ErrNotFound {
+		c.reply("NOT_FOUND")
+		return keyArg, nil
+	}
+	if err != nil {
+		return keyArg, err
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return keyArg, &badRequestError{"cannot increment or decrement non-numeric value"}
+	}
+	if cmd.Name == "incr" {
+		n += delta
+	} else {
+		n -= min(delta, n)
+	}
+
+	at, hadTTL := c.s.expiry.get(key)
+	if _, err := c.s.putValue(ctx, key, strconv.FormatUint(n, 10)); err != nil {
+		return keyArg, err
+	}
+	if hadTTL {
+		c.s.expiry.set(key, at)
+	}
+	c.reply(strconv.FormatUint(n, 10))
+	return keyArg, nil
+}
+
+// touch replaces the deadline of an existing key; exptime 0 clears it.
+func (c *mcConn) touch(ctx context.Context, cmd memcache.Command) (string, error) {
+	keyArg := cmd.Args[0]
+	exptime, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return keyArg, &badRequestError{"invalid exptime argument"}
+	}
+	key, err := c.key(ctx, keyArg, "/put")
+	if err != nil {
+		return keyArg, err
+	}
+	if err := c.s.allowed(ctx, acl.OpPut, strconv.Itoa(key)); err != nil {
+		return keyArg, err
+	}
+
+	unlock := c.s.lock(key)
+	defer unlock()
+
+	_, _, err = c.s.getValue(ctx, key)
+	if err == store.ErrNotFound {
+		c.reply("NOT_FOUND")
+		return keyArg, nil
+	}
+	if err != nil {
+		return keyArg, err
+	}
+	if at, ok := mcDeadline(exptime); ok {
+		c.s.expiry.set(key, at)
+	} else {
+		c.s.expiry.clear(key)
+	}
+	c.reply("TOUCHED")
+	return keyArg, nil
+}
+
+// del answers delete. The optional time argument of old clients must be 0.
+func (c *mcConn) del(ctx context.Context, cmd memcache.Command) (string, error) {
+	keyArg := cmd.Args[0]
+	if len(cmd.Args) == 2 && cmd.Args[1] != "0" {
+		return keyArg, &badRequestError{"bad command line format. Usage: delete <key> [noreply]"}
+	}
+	key, err := c.key(ctx, keyArg, "/delete")
+	if err != nil {
+		return keyArg, err
+	}
+	if c.s.expired(key) {
+		c.reply("NOT_FOUND")
+		return keyArg, nil
+	}
+	_, err = c.s.deleteValue(ctx, key)
+	if err == store.ErrNotFound {
+		c.reply("NOT_FOUND")
+		return keyArg, nil
+	}
+	if err != nil {
+		return keyArg, err
+	}
+	c.reply("DELETED")
+	return keyArg, nil
+}
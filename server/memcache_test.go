@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialMemcache serves one memcached connection from s over an in-memory
+// pipe.
+func dialMemcache(t *testing.T, s *Server) (io.Writer, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	c := s.newMemcacheConn(server)
+	done := make(chan struct{})
+	go func() {
+		c.serve()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	return client, bufio.NewReader(client)
+}
+
+// memcacheExpect returns a function that sends a request and fails the
+// test unless the reply lines are want.
+func memcacheExpect(t *testing.T, w io.Writer, r *bufio.Reader) func(send string, want ...string) {
+	return func(send string, want ...string) {
+		t.Helper()
+		if _, err := io.WriteString(w, send); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range want {
+			got, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: %v", send, err)
+			}
+			if got = strings.TrimSuffix(got, "\r\n"); got != line {
+				t.Fatalf("%q: got %q, want %q", send, got, line)
+			}
+		}
+	}
+}
+
+// gets returns the CAS token gets gives for key, which must hold value.
+func gets(t *testing.T, w io.Writer, r *bufio.Reader, key, value string) string {
+	t.Helper()
+	if _, err := io.WriteString(w, "gets "+key+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 5 || fields[0] != "VALUE" || fields[1] != key {
+		t.Fatalf("gets %s: got %q", key, line)
+	}
+	memcacheExpect(t, w, r)("", value, "END")
+	return fields[4]
+}
+
+func TestMemcacheRejectsFlags(t *testing.T) {
+	w, r := dialMemcache(t, newTestServer(t, testConfig()))
+	expect := memcacheExpect(t, w, r)
+
+	expect("set 1 0 0 3\r\nabc\r\n", "STORED")
+	expect("set 1 2 0 3\r\nxyz\r\n", "CLIENT_ERROR flags are not supported, only 0")
+	expect("add 2 1 0 3\r\nxyz\r\n", "CLIENT_ERROR flags are not supported, only 0")
+	// The rejected data blocks were consumed and the value kept.
+	expect("get 1 2\r\n", "VALUE 1 0 3", "abc", "END")
+	expect("set 1 x 0 3\r\nabc\r\n", "CLIENT_ERROR bad command line format")
+}
+
+func TestMemcacheCAS(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	w, r := dialMemcache(t, s)
+	expect := memcacheExpect(t, w, r)
+
+	expect("cas 1 0 0 1 1\r\na\r\n", "NOT_FOUND")
+	expect("set 1 0 0 3\r\nabc\r\n", "STORED")
+	token := gets(t, w, r, "1", "abc")
+	if again := gets(t, w, r, "1", "abc"); again != token {
+		t.Errorf("gets of an unchanged key: token %s, then %s", token, again)
+	}
+
+	// A write in between makes the token stale.
+	expect("set 1 0 0 3\r\nxyz\r\n", "STORED")
+	expect("cas 1 0 0 3 "+token+"\r\nnew\r\n", "EXISTS")
+	token = gets(t, w, r, "1", "xyz")
+	expect("cas 1 0 0 3 "+token+"\r\nnew\r\n", "STORED")
+	expect("cas 1 0 0 3 "+token+"\r\nold\r\n", "EXISTS")
+	expect("get 1\r\n", "VALUE 1 0 3", "new", "END")
+
+	// Deleting and re-creating the key brings its version back to 1,
+	// but not its token.
+	expect("set 2 0 0 1\r\na\r\n", "STORED")
+	token = gets(t, w, r, "2", "a")
+	expect("delete 2\r\n", "DELETED")
+	expect("cas 2 0 0 1 "+token+"\r\nb\r\n", "NOT_FOUND")
+	expect("set 2 0 0 1\r\nc\r\n", "STORED")
+	expect("cas 2 0 0 1 "+token+"\r\nb\r\n", "EXISTS")
+	if fresh := gets(t, w, r, "2", "c"); fresh == token {
+		t.Errorf("re-created key got the token %s again", token)
+	}
+
+	// A token never issued does not match, even if it equals the version.
+	expect("set 3 0 0 1\r\na\r\n", "STORED")
+	expect("cas 3 0 0 1 1\r\nb\r\n", "EXISTS")
+	expect("cas 3 0 0 1 x\r\nb\r\n", "CLIENT_ERROR bad command line format")
+
+	// Writes from another protocol count too.
+	token = gets(t, w, r, "3", "a")
+	if _, err := s.putValue(context.Background(), 3, "http"); err != nil {
+		t.Fatal(err)
+	}
+	expect("cas 3 0 0 1 "+token+"\r\nb\r\n", "EXISTS")
+}
+
+func TestMemcacheAddReplace(t *testing.T) {
+	w, r := dialMemcache(t, newTestServer(t, testConfig()))
+	expect := memcacheExpect(t, w, r)
+
+	expect("replace 1 0 0 1\r\na\r\n", "NOT_STORED")
+	expect("add 1 0 0 1\r\na\r\n", "STORED")
+	expect("add 1 0 0 1\r\nb\r\n", "NOT_STORED")
+	expect("replace 1 0 0 1\r\nc\r\n", "STORED")
+	expect("get 1\r\n", "VALUE 1 0 1", "c", "END")
+	expect("add 2 0 0 1 noreply\r\nd\r\nget 2\r\n", "VALUE 2 0 1", "d", "END")
+}
+
+func TestMemcacheIncrDecr(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	w, r := dialMemcache(t, s)
+	expect := memcacheExpect(t, w, r)
+
+	expect("incr 1 1\r\n", "NOT_FOUND")
+	expect("set 1 0 0 2\r\n10\r\n", "STORED")
+	expect("incr 1 5\r\n", "15")
+	expect("decr 1 3\r\n", "12")
+	// decr stops at 0 and incr wraps around, as in memcached.
+	expect("decr 1 100\r\n", "0")
+	expect("set 1 0 0 20\r\n18446744073709551615\r\n", "STORED")
+	expect("incr 1 2\r\n", "1")
+	expect("incr 1 x\r\n", "CLIENT_ERROR invalid numeric delta argument")
+	expect("set 2 0 0 1\r\na\r\n", "STORED")
+	expect("incr 2 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+
+	// The key keeps its deadline.
+	expect("set 3 0 100 1\r\n1\r\n", "STORED")
+	expect("incr 3 1\r\n", "2")
+	if _, ok := s.expiry.get(3); !ok {
+		t.Error("incr cleared the deadline")
+	}
+}
+
+func TestMemcacheTouch(t *testing.T) {
+	cfg := testConfig()
+	cfg.ACL.File = filepath.Join(t.TempDir(), "acl.yaml")
+	policy := "rules:\n  - principal: \"*\"\n    operations: [get, put, delete]\n    keys: [\"7\"]\n"
+	if err := os.WriteFile(cfg.ACL.File, []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, cfg)
+	w, r := dialMemcache(t, s)
+	expect := memcacheExpect(t, w, r)
+
+	expect("touch 7 100\r\n", "NOT_FOUND")
+	expect("set 7 0 0 1\r\na\r\n", "STORED")
+	expect("touch 7 100\r\n", "TOUCHED")
+	if _, ok := s.expiry.get(7); !ok {
+		t.Error("touch set no deadline")
+	}
+	// "007" is key 7, so the ACL must judge it as "7".
+	expect("touch 007 0\r\n", "TOUCHED")
+	if _, ok := s.expiry.get(7); ok {
+		t.Error("touch with exptime 0 kept the deadline")
+	}
+	// A deadline in the past deletes the key.
+	expect("touch 7 -1\r\n", "TOUCHED")
+	expect("get 7\r\n", "END")
+	expect("touch 7 x\r\n", "CLIENT_ERROR invalid exptime argument")
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"decsproject/logging"
+	"decsproject/tracing"
+)
+
+// requestInfo collects details handlers know about a request for its
+// access log line.
+type requestInfo struct {
+	key       string
+	cache     string
+	principal string
+}
+
+type requestInfoKey struct{}
+
+// info returns the requestInfo attached by observe, or a throwaway value
+// when the request did not pass through it.
+func info(ctx context.Context) *requestInfo {
+	if ri, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return ri
+	}
+	return &requestInfo{}
+}
+
+// statusRecorder captures the status code and body size written by a
+// handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// route returns the mux pattern that served req, or "other" for requests
+// that matched nothing. It is only set once the mux has run.
+func route(req *http.Request) string {
+	if req.Pattern == "" {
+		return "other"
+	}
+	return req.Pattern
+}
+
+// observe wraps every request: it assigns a request ID, taken from a valid
+// X-Request-ID header or generated, starts a server span continuing any
+// client traceparent, bounds the request by server.request_timeout or the
+// server.endpoint_timeouts entry for its path, and once the handler
+// returns records metrics and writes a structured access log line.
+// Storage calls inherit the deadline through the request context.
+func (s *Server) observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		id := req.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+
+		ri := &requestInfo{}
+		ctx := logging.WithRequestID(req.Context(), id)
+		ctx = context.WithValue(ctx, requestInfoKey{}, ri)
+		if sc, err := tracing.ParseTraceParent(req.Header.Get(tracing.TraceParentHeader)); err == nil {
+			ctx = tracing.ContextWithRemote(ctx, sc)
+		}
+		ctx, span := tracing.Start(ctx, req.Method, tracing.KindServer)
+		ctx, cancel := context.WithTimeout(ctx, s.requestTimeout(req.URL.Path))
+		defer cancel()
+
+		// The mux records the matched pattern on the request it is given,
+		// so keep hold of it to label metrics by route. Wrappers between
+		// here and the mux must pass it on rather than a copy.
+		inner := req.WithContext(ctx)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, inner)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+
+		s.metrics.ObserveRequest(route(inner), req.Method, rec.status, elapsed)
+
+		span.SetName(req.Method + " " + route(inner))
+		span.SetAttr("http.method", req.Method)
+		span.SetAttr("http.route", route(inner))
+		span.SetAttr("http.status_code", rec.status)
+		span.SetAttr("request_id", id)
+		if ri.key != "" {
+			span.SetAttr("kv.key", ri.key)
+		}
+		if ri.principal != "" {
+			span.SetAttr("enduser.id", ri.principal)
+		}
+		if rec.status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(rec.status)))
+		}
+		span.End()
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if rate := s.cfg.Log.SampleRate; rate < 1 && rand.Float64() >= rate {
+			return
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.String("proto", req.Proto),
+			slog.Int("status", rec.status),
+			slog.Float64("latency_ms", float64(elapsed.Microseconds())/1000),
+			slog.Int("bytes", rec.bytes),
+		}
+		if ri.key != "" {
+			attrs = append(attrs, slog.String("key", ri.key))
+		}
+		if ri.cache != "" {
+			attrs = append(attrs, slog.String("cache", ri.cache))
+		}
+		if ri.principal != "" {
+			attrs = append(attrs, slog.String("principal", ri.principal))
+		}
+		if sc := span.SpanContext(); sc.Sampled {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID.String()))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"decsproject/config"
+	"decsproject/inject"
+	"decsproject/logging"
+	"decsproject/store"
+)
+
+func TestHandlerLabelsMetricsByRoute(t *testing.T) {
+	cfg := testConfig()
+	// /delete runs out of time in the injected latency, so its deadline
+	// must come from the same middleware chain that the mux labels.
+	cfg.Server.EndpointTimeouts = config.Durations{"/delete": time.Millisecond}
+	cfg.Inject = inject.State{Enabled: true, Endpoints: map[string]inject.Settings{"/delete": {Latency: 20 * time.Millisecond}}}
+	s, err := New(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	for _, tc := range []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"PUT", "/put", `{"key":1,"value":"one"}`, http.StatusOK},
+		{"GET", "/get?key=1", "", http.StatusOK},
+		{"DELETE", "/delete", `{"key":1}`, http.StatusServiceUnavailable},
+		{"GET", "/nowhere", "", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Fatalf("%s %s: got %d %q, want %d", tc.method, tc.target, rec.Code, rec.Body.String(), tc.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+	for _, want := range []string{
+		`kv_http_requests_total{endpoint="/put",method="PUT",status="200"} 1`,
+		`kv_http_requests_total{endpoint="/get",method="GET",status="200"} 1`,
+		`kv_http_requests_total{endpoint="/delete",method="DELETE",status="503"} 1`,
+		`kv_http_requests_total{endpoint="other",method="GET",status="404"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+// captureLog sends the default logger to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(logging.New(&buf, slog.LevelInfo))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// accessLines decodes the access log lines in buf.
+func accessLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if m["msg"] == "request" {
+			lines = append(lines, m)
+		}
+	}
+	return lines
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	for _, tc := range []struct {
+		rate float64
+		want int
+	}{
+		{1, 5},
+		// Errors are always logged.
+		{0, 1},
+	} {
+		buf := captureLog(t)
+		st, _ := openBreaker(t)
+		cfg := testConfig()
+		cfg.Log.SampleRate = tc.rate
+		s, err := New(cfg, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := s.Handler()
+		for range 4 {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+		}
+		// A cache miss fails fast with the breaker open.
+		req := httptest.NewRequest("GET", "/get?key=1", nil)
+		req.Header.Set(logging.RequestIDHeader, "sampling-test")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := accessLines(t, buf)
+		if len(lines) != tc.want {
+			t.Fatalf("sample_rate %v: %d access lines, want %d:\n%s", tc.rate, len(lines), tc.want, buf)
+		}
+		last := lines[len(lines)-1]
+		if last["level"] != "ERROR" || last["status"] != float64(http.StatusServiceUnavailable) || last["request_id"] != "sampling-test" {
+			t.Errorf("sample_rate %v: error line %v", tc.rate, last)
+		}
+	}
+}
@@ -0,0 +1,700 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"decsproject/acl"
+	"decsproject/auth"
+	"decsproject/quota"
+	"decsproject/resp"
+	"decsproject/store"
+	"decsproject/tracing"
+)
+
+// respProtocol labels RESP requests in metrics and logs.
+const respProtocol = "resp"
+
+// respScanCount is how many keys SCAN looks at without COUNT.
+const respScanCount = 10
+
+// respCommand is one supported Redis command.
+type respCommand struct {
+	// arity counts the command name too; -n means at least n.
+	arity int
+	// endpoint names the HTTP path whose rate limit, timeout and scope
+	// the command shares; empty for connection commands, which can run
+	// before authenticating.
+	endpoint string
+	run      func(c *respConn, ctx context.Context, args []string) (key string, err error)
+}
+
+var respCommands = map[string]respCommand{
+	"HELLO":   {-1, "", (*respConn).hello},
+	"AUTH":    {-2, "", (*respConn).auth},
+	"QUIT":    {1, "", (*respConn).ok},
+	"SELECT":  {2, "", (*respConn).selectDB},
+	"CLIENT":  {-2, "", (*respConn).clientCmd},
+	"COMMAND": {-1, "", (*respConn).command},
+	"PING":    {-1, "/get", (*respConn).ping},
+	"GET":     {2, "/get", (*respConn).get},
+	"MGET":    {-2, "/get", (*respConn).mget},
+	"EXISTS":  {-2, "/get", (*respConn).exists},
+	"TTL":     {2, "/get", (*respConn).ttl},
+	"SCAN":    {-2, "/get", (*respConn).scan},
+	"SET":     {-3, "/put", (*respConn).set},
+	"MSET":    {-3, "/put", (*respConn).mset},
+	"INCR":    {2, "/put", (*respConn).incr},
+	"EXPIRE":  {3, "/put", (*respConn).expire},
+	"DEL":     {-2, "/delete", (*respConn).del},
+}
+
+// respConn is one RESP connection. Commands are answered in order;
+// replies are flushed once no more pipelined commands are buffered.
+type respConn struct {
+	streamConn
+	s *Server
+	r *resp.Reader
+	w *resp.Writer
+}
+
+func (s *Server) newRESPConn(conn net.Conn) protoConn {
+	return &respConn{
+		streamConn: newStreamConn(conn, s.cfg.Server.IdleTimeout),
+		s:          s,
+		r:          resp.NewReader(conn, s.cfg.Server.MaxBodyBytes),
+		w:          resp.NewWriter(conn),
+	}
+}
+
+func (c *respConn) serve() {
+	defer c.conn.Close()
+
+	for c.awaitRequest() {
+		args, err := c.r.ReadCommand()
+		if errors.Is(err, resp.ErrProtocol) {
+			c.w.Error("ERR Protocol error: " + strings.TrimPrefix(err.Error(), resp.ErrProtocol.Error()+": "))
+			c.flush()
+			return
+		}
+		if err != nil {
+			if !quietReadError(err) {
+				slog.Debug("RESP read failed", "remote", c.client, "err", err)
+			}
+			return
+		}
+
+		c.dispatch(args)
+		if strings.EqualFold(args[0], "QUIT") {
+			c.flush()
+			return
+		}
+		if c.r.Buffered() == 0 && c.flush() != nil {
+			return
+		}
+	}
+}
+
+func (c *respConn) flush() error {
+	if d := c.s.cfg.Server.WriteTimeout; d > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	return c.w.Flush()
+}
+
+// dispatch runs one command and writes its reply.
+func (c *respConn) dispatch(args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, found := respCommands[name]
+	op := strings.ToLower(name)
+	if !found {
+		op = "unknown"
+	}
+	ctx, span := tracing.Start(c.context(), "resp "+op, tracing.KindServer)
+	start := time.Now()
+
+	var (
+		key string
+		err error
+	)
+	switch {
+	case !found:
+		err = &badRequestError{fmt.Sprintf("unknown command '%s'", args[0])}
+	case cmd.arity > 0 && len(args) != cmd.arity, cmd.arity < 0 && len(args) < -cmd.arity:
+		err = &badRequestError{fmt.Sprintf("wrong number of arguments for '%s' command", op)}
+	case cmd.endpoint == "":
+		key, err = cmd.run(c, ctx, args[1:])
+	default:
+		scope := auth.ScopeWrite
+		if cmd.endpoint == "/get" {
+			scope = auth.ScopeRead
+		}
+		if err = c.s.checkScope(ctx, scope); err != nil {
+			break
+		}
+		opCtx, cancel := context.WithTimeout(ctx, c.s.requestTimeout(cmd.endpoint))
+		key, err = cmd.run(c, opCtx, args[1:])
+		cancel()
+	}
+	c.s.observeOp(ctx, span, respProtocol, op, key, err, start)
+
+	if err != nil {
+		c.w.Error(respError(err))
+	}
+}
+
+// respError is the error reply for an error from a command, led by the
+// error code Redis clients expect.
+func respError(err error) string {
+	var limited *rateLimitedError
+	switch opResult(err) {
+	case resultUnauthorized:
+		if errors.Is(err, errUnauthenticated) {
+			return "NOAUTH Authentication required."
+		}
+		return "WRONGPASS invalid username-password pair or user is disabled."
+	case resultForbidden:
+		if errors.Is(err, quota.ErrExceeded) {
+			return "OOM " + err.Error()
+		}
+		return "NOPERM " + err.Error()
+	case resultRateLimited:
+		errors.As(err, &limited)
+		return fmt.Sprintf("ERR rate limit exceeded, retry after %ds", limited.retryAfter())
+	case resultUnavailable:
+		return "TRYAGAIN storage unavailable, try again later"
+	case resultTimeout:
+		return "ERR request deadline exceeded"
+	case resultError:
+		return "ERR internal error"
+	}
+	return "ERR " + err.Error()
+}
+
+var errRESPSyntax = &badRequestError{"syntax error"}
+
+// key parses a key argument and applies the rate limit for endpoint and
+// the key size limit to it.
+func (c *respConn) key(ctx context.Context, arg, endpoint string) (int, error) {
+	key, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, &badRequestError{"key must be an integer"}
+	}
+	if err := c.s.limit(ctx, c.client, endpoint); err != nil {
+		return 0, err
+	}
+	return key, c.s.checkKey(key)
+}
+
+// value checks a value argument against the size limit.
+func (c *respConn) value(arg string) error {
+	if arg == "" {
+		return &badRequestError{"empty values are not supported"}
+	}
+	return c.s.checkValue(arg)
+}
+
+// login authenticates the connection with password, tried as an API key
+// and then as a bearer token. Without auth.enabled any password is
+// accepted and the connection stays anonymous.
+func (c *respConn) login(password string) error {
+	if !c.s.cfg.Auth.Enabled {
+		return nil
+	}
+	p, err := c.s.auth.AuthenticateKey(password)
+	if err != nil {
+		p, err = c.s.auth.AuthenticateToken(password)
+	}
+	if err != nil {
+		return err
+	}
+	c.principal.Store(p)
+	return nil
+}
+
+func (c *respConn) hello(ctx context.Context, args []string) (string, error) {
+	proto := c.w.Proto
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", &badRequestError{"Protocol version is not an integer or out of range"}
+		}
+		if v != 2 && v != 3 {
+			c.w.Error("NOPROTO unsupported protocol version")
+			return "", nil
+		}
+		proto = v
+		args = args[1:]
+	}
+	for len(args) > 0 {
+		switch {
+		case strings.EqualFold(args[0], "AUTH") && len(args) >= 3:
+			if err := c.login(args[2]); err != nil {
+				return "", err
+			}
+			args = args[3:]
+		case strings.EqualFold(args[0], "SETNAME") && len(args) >= 2:
+			args = args[2:]
+		default:
+			return "", errRESPSyntax
+		}
+	}
+	if c.s.cfg.Auth.Enabled && c.principal.Load() == nil {
+		return "", errUnauthenticated
+	}
+
+	c.w.Proto = proto
+	c.w.Map(6)
+	c.w.Bulk("server")
+	c.w.Bulk("kv")
+	c.w.Bulk("version")
+	c.w.Bulk("1.0.0")
+	c.w.Bulk("proto")
+	c.w.Int(int64(proto))
+	c.w.Bulk("mode")
+	c.w.Bulk("standalone")
+	c.w.Bulk("role")
+	c.w.Bulk("master")
+	c.w.Bulk("modules")
+	c.w.Array(0)
+	return "", nil
+}
+
+// auth takes AUTH password or AUTH username password; the username is
+// ignored. A failed attempt leaves the connection as it was.
+func (c *respConn) auth(ctx context.Context, args []string) (string, error) {
+	if len(args) > 2 {
+		return "", errRESPSyntax
+	}
+	if err := c.login(args[len(args)-1]); err != nil {
+		return "", err
+	}
+	c.w.SimpleString("OK")
+	return "", nil
+}
+
+func (c *respConn) ok(ctx context.Context, args []string) (string, error) {
+	c.w.SimpleString("OK")
+	return "", nil
+}
+
+// selectDB accepts only database 0; there is one keyspace.
+func (c *respConn) selectDB(ctx context.Context, args []string) (string, error) {
+	if args[0] != "0" {
+		return "", &badRequestError{"DB index is out of range"}
+	}
+	c.w.SimpleString("OK")
+	return "", nil
+}
+
+// clientCmd accepts the CLIENT SETNAME and SETINFO calls client libraries
+// make on connecting.
+func (c *respConn) clientCmd(ctx context.Context, args []string) (string, error) {
+	switch strings.ToUpper(args[0]) {
+	case "SETNAME", "SETINFO":
+		c.w.SimpleString("OK")
+		return "", nil
+	}
+	return "", &badRequestError{"unknown subcommand '" + args[0] + "'"}
+}
+
+// command answers COMMAND, and COMMAND DOCS as sent by redis-cli, with an
+// empty list.
+func (c *respConn) command(ctx context.Context, args []string) (string, error) {
+	c.w.Array(0)
+	return "", nil
+}
+
+func (c *respConn) ping(ctx context.Context, args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		c.w.SimpleString("PONG")
+	case 1:
+		c.w.Bulk(args[0])
+	default:
+		return "", &badRequestError{"wrong number of arguments for 'ping' command"}
+	}
+	return "", nil
+}
+
+func (c *respConn) get(ctx context.Context, args []string) (string, error) {
+	key, err := c.key(ctx, args[0], "/get")
+	if err != nil {
+		return args[0], err
+	}
+	value, _, err := c.s.getValue(ctx, key)
+	if err == store.ErrNotFound {
+		c.w.Null()
+		return args[0], nil
+	}
+	if err != nil {
+		return args[0], err
+	}
+	c.w.Bulk(value)
+	return args[0], nil
+}
+
+func (c *respConn) mget(ctx context.Context, args []string) (string, error) {
+	values := make([]*string, len(args))
+	for i, arg := range args {
+		key, err := c.key(ctx, arg, "/get")
+		if err != nil {
+			return "", err
+		}
+		value, _, err := c.s.getValue(ctx, key)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		values[i] = &value
+	}
+
+	c.w.Array(len(values))
+	for _, v := range values {
+		if v == nil {
+			c.w.Null()
+		} else {
+			c.w.Bulk(*v)
+		}
+	}
+	return "", nil
+}
+
+func (c *respConn) exists(ctx context.Context, args []string) (string, error) {
+	n := 0
+	for _, arg := range args {
+		key, err := c.key(ctx, arg, "/get")
+		if err != nil {
+			return "", err
+		}
+		_, _, err = c.s.getValue(ctx, key)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		n++
+	}
+	c.w.Int(int64(n))
+	return "", nil
+}
+
+// ttl answers -2 for a missing key, -1 for one without a deadline and the
+// seconds left otherwise.
+func (c *respConn) ttl(ctx context.Context, args []string) (string, error) {
+	key, err := c.key(ctx, args[0], "/get")
+	if err != nil {
+		return args[0], err
+	}
+	_, _, err = c.s.getValue(ctx, key)
+	if err == store.ErrNotFound {
+		c.w.Int(-2)
+		return args[0], nil
+	}
+	if err != nil {
+		return args[0], err
+	}
+
+	at, found := c.s.expiry.get(key)
+	if !found {
+		c.w.Int(-1)
+		return args[0], nil
+	}
+	c.w.Int(int64((time.Until(at) + 500*time.Millisecond) / time.Second))
+	return args[0], nil
+}
+
+// scan takes SCAN cursor [MATCH pattern] [COUNT count]. Cursors encode the
+// next key to look at; keys the ACL does not let the client scan are
+// skipped.
+func (c *respConn) scan(ctx context.Context, args []string) (string, error) {
+	from, ok := decodeCursor(args[0])
+	if !ok {
+		return "", &badRequestError{"invalid cursor"}
+	}
+	pattern, count := "*", respScanCount
+	for rest := args[1:]; len(rest) > 0; rest = rest[2:] {
+		if len(rest) < 2 {
+			return "", errRESPSyntax
+		}
+		switch strings.ToUpper(rest[0]) {
+		case "MATCH":
+			pattern = rest[1]
+			if _, err := path.Match(pattern, ""); err != nil {
+				return "", &badRequestError{"invalid MATCH pattern"}
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(rest[1])
+			if err != nil || n < 1 {
+				return "", &badRequestError{"value is not an integer or out of range"}
+			}
+			count = min(n, 1000)
+		default:
+			return "", errRESPSyntax
+		}
+	}
+	if err := c.s.limit(ctx, c.client, "/get"); err != nil {
+		return "", err
+	}
+
+	keys, err := store.Keys(ctx, c.s.store, from, count+1)
+	if err != nil {
+		return "", err
+	}
+	next := "0"
+	if len(keys) > count {
+		next = encodeCursor(keys[count])
+		keys = keys[:count]
+	}
+
+	principal := principalName(ctx)
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		keyStr := strconv.Itoa(key)
+		if ok, _ := path.Match(pattern, keyStr); !ok {
+			continue
+		}
+		if !c.s.acl.Allowed(principal, acl.OpScan, keyStr) || c.s.expired(key) {
+			continue
+		}
+		matched = append(matched, keyStr)
+	}
+
+	c.w.Array(2)
+	c.w.Bulk(next)
+	c.w.Array(len(matched))
+	for _, key := range matched {
+		c.w.Bulk(key)
+	}
+	return "", nil
+}
+
+// encodeCursor zigzag-encodes key and adds one, so any key, negative ones
+// included, maps to a cursor other than "0", which ends a scan.
+func encodeCursor(key int) string {
+	k := int64(key)
+	return strconv.FormatUint(uint64(k<<1)^uint64(k>>63)+1, 10)
+}
+
+func decodeCursor(cursor string) (int, bool) {
+	u, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if u == 0 {
+		return math.MinInt, true
+	}
+	u--
+	return int(int64(u>>1) ^ -int64(u&1)), true
+}
+
+// set takes SET key value [EX seconds | PX milliseconds] [NX | XX]
+// [KEEPTTL]. NX and XX are checked under the key's lock, so they hold
+// against other RESP commands but not against plain puts from other
+// protocols.
+func (c *respConn) set(ctx context.Context, args []string) (string, error) {
+	var (
+		ttl          time.Duration
+		nx, xx, keep bool
+	)
+	for rest := args[2:]; len(rest) > 0; rest = rest[1:] {
+		switch opt := strings.ToUpper(rest[0]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keep = true
+		case "EX", "PX":
+			if len(rest) < 2 || ttl != 0 {
+				return args[0], errRESPSyntax
+			}
+			n, err := strconv.ParseInt(rest[1], 10, 64)
+			if err != nil {
+				return args[0], &badRequestError{"value is not an integer or out of range"}
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > int64(math.MaxInt64/unit) {
+				return args[0], &badRequestError{"invalid expire time in 'set' command"}
+			}
+			ttl = time.Duration(n) * unit
+			rest = rest[1:]
+		default:
+			return args[0], errRESPSyntax
+		}
+	}
+	if (nx && xx) || (keep && ttl != 0) {
+		return args[0], errRESPSyntax
+	}
+
+	key, err := c.key(ctx, args[0], "/put")
+	if err != nil {
+		return args[0], err
+	}
+	if err := c.value(args[1]); err != nil {
+		return args[0], err
+	}
+
+	unlock := c.s.lock(key)
+	defer unlock()
+
+	if nx || xx {
+		_, _, err := c.s.getValue(ctx, key)
+		if err != nil && err != store.ErrNotFound {
+			return args[0], err
+		}
+		if exists := err == nil; (nx && exists) || (xx && !exists) {
+			c.w.Null()
+			return args[0], nil
+		}
+	}
+
+	at, hadTTL := c.s.expiry.get(key)
+	if _, err := c.s.putValue(ctx, key, args[1]); err != nil {
+		return args[0], err
+	}
+	if ttl != 0 {
+		c.s.expiry.set(key, time.Now().Add(ttl))
+	} else if keep && hadTTL {
+		c.s.expiry.set(key, at)
+	}
+	c.w.SimpleString("OK")
+	return args[0], nil
+}
+
+// mset stores every pair in order. It is not atomic: if one put fails the
+// ones before it stay applied.
+func (c *respConn) mset(ctx context.Context, args []string) (string, error) {
+	if len(args)%2 != 0 {
+		return "", &badRequestError{"wrong number of arguments for 'mset' command"}
+	}
+	keys := make([]int, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, err := c.key(ctx, args[i], "/put")
+		if err != nil {
+			return "", err
+		}
+		if err := c.value(args[i+1]); err != nil {
+			return "", err
+		}
+		keys = append(keys, key)
+	}
+
+	for i, key := range keys {
+		if _, err := c.s.putValue(ctx, key, args[2*i+1]); err != nil {
+			return "", err
+		}
+	}
+	c.w.SimpleString("OK")
+	return "", nil
+}
+
+// incr adds one to the integer stored at key, starting from 0, and keeps
+// any deadline the key has.
+func (c *respConn) incr(ctx context.Context, args []string) (string, error) {
+	key, err := c.key(ctx, args[0], "/put")
+	if err != nil {
+		return args[0], err
+	}
+
+	unlock := c.s.lock(key)
+	defer unlock()
+
+	var n int64
+	value, _, err := c.s.getValue(ctx, key)
+	switch {
+	case err == store.ErrNotFound:
+	case err != nil:
+		return args[0], err
+	default:
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return args[0], &badRequestError{"value is not an integer or out of range"}
+		}
+	}
+	if n == math.MaxInt64 {
+		return args[0], &badRequestError{"increment or decrement would overflow"}
+	}
+	n++
+
+	at, hadTTL := c.s.expiry.get(key)
+	if _, err := c.s.putValue(ctx, key, strconv.FormatInt(n, 10)); err != nil {
+		return args[0], err
+	}
+	if hadTTL {
+		c.s.expiry.set(key, at)
+	}
+	c.w.Int(n)
+	return args[0], nil
+}
+
+// expire sets a deadline on an existing key and answers 1, or 0 if the key
+// is missing. A deadline that is not in the future deletes the key.
+func (c *respConn) expire(ctx context.Context, args []string) (string, error) {
+	key, err := c.key(ctx, args[0], "/put")
+	if err != nil {
+		return args[0], err
+	}
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || seconds > math.MaxInt64/int64(time.Second) {
+		return args[0], &badRequestError{"value is not an integer or out of range"}
+	}
+	if err := c.s.allowed(ctx, acl.OpPut, strconv.Itoa(key)); err != nil {
+		return args[0], err
+	}
+
+	unlock := c.s.lock(key)
+	defer unlock()
+
+	_, _, err = c.s.getValue(ctx, key)
+	if err == store.ErrNotFound {
+		c.w.Int(0)
+		return args[0], nil
+	}
+	if err != nil {
+		return args[0], err
+	}
+
+	if seconds <= 0 {
+		if _, err := c.s.deleteValue(ctx, key); err != nil && err != store.ErrNotFound {
+			return args[0], err
+		}
+	} else {
+		c.s.expiry.set(key, time.Now().Add(time.Duration(seconds)*time.Second))
+	}
+	c.w.Int(1)
+	return args[0], nil
+}
+
+func (c *respConn) del(ctx context.Context, args []string) (string, error) {
+	n := 0
+	for _, arg := range args {
+		key, err := c.key(ctx, arg, "/delete")
+		if err != nil {
+			return "", err
+		}
+		_, err = c.s.deleteValue(ctx, key)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		n++
+	}
+	c.w.Int(int64(n))
+	return "", nil
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"decsproject/config"
+	"decsproject/resp"
+	"decsproject/store"
+)
+
+// respClient sends commands with resp.Writer, as Redis clients frame them,
+// and reads replies back in a compact text form:
+//
+//	+OK  -ERR msg  :1  "bulk"  nil ($-1)  _ (RESP3 null)  [a b]  %{k v}
+type respClient struct {
+	t *testing.T
+	w *resp.Writer
+	r *bufio.Reader
+}
+
+// dialRESP serves one RESP connection from s over an in-memory pipe.
+func dialRESP(t *testing.T, s *Server) *respClient {
+	t.Helper()
+	client, server := net.Pipe()
+	c := s.newRESPConn(server)
+	done := make(chan struct{})
+	go func() {
+		c.serve()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	return &respClient{t: t, w: resp.NewWriter(client), r: bufio.NewReader(client)}
+}
+
+func (c *respClient) do(args ...string) string {
+	c.t.Helper()
+	c.w.Array(len(args))
+	for _, arg := range args {
+		c.w.Bulk(arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.t.Fatalf("%v: %v", args, err)
+	}
+	reply, err := c.reply()
+	if err != nil {
+		c.t.Fatalf("%v: %v", args, err)
+	}
+	return reply
+}
+
+// expect runs a command and fails the test unless the reply is want.
+func (c *respClient) expect(want string, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); got != want {
+		c.t.Errorf("%s: got %s, want %s", strings.Join(args, " "), got, want)
+	}
+}
+
+func (c *respClient) reply() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply line")
+	}
+	switch body := line[1:]; line[0] {
+	case '+', '-', ':':
+		return line, nil
+	case '_':
+		return "_", nil
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return "", err
+		}
+		if n < 0 {
+			return "nil", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return "", err
+		}
+		return strconv.Quote(string(buf[:n])), nil
+	case '*', '%':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return "", err
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		elems := make([]string, n)
+		for i := range elems {
+			if elems[i], err = c.reply(); err != nil {
+				return "", err
+			}
+		}
+		if line[0] == '%' {
+			return "%{" + strings.Join(elems, " ") + "}", nil
+		}
+		return "[" + strings.Join(elems, " ") + "]", nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
+
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	s, err := New(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRESPGetSet(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	c.expect("+OK", "SET", "1", "a")
+	c.expect(`"a"`, "GET", "1")
+	c.expect("nil", "GET", "2")
+	c.expect("-ERR key must be an integer", "GET", "one")
+
+	c.expect("nil", "SET", "1", "b", "NX")
+	c.expect(`"a"`, "GET", "1")
+	c.expect("nil", "SET", "2", "b", "XX")
+	c.expect("nil", "GET", "2")
+	c.expect("+OK", "SET", "2", "b", "NX")
+	c.expect("+OK", "SET", "1", "c", "XX")
+	c.expect(`"c"`, "GET", "1")
+	c.expect("-ERR syntax error", "SET", "1", "d", "NX", "XX")
+
+	c.expect("+OK", "SET", "3", "v", "EX", "100")
+	c.expect(":100", "TTL", "3")
+	c.expect("+OK", "SET", "3", "w", "KEEPTTL")
+	c.expect(":100", "TTL", "3")
+	c.expect("+OK", "SET", "3", "x")
+	c.expect(":-1", "TTL", "3")
+	c.expect("-ERR syntax error", "SET", "3", "x", "KEEPTTL", "EX", "5")
+	c.expect("-ERR syntax error", "SET", "3", "x", "EX", "5", "PX", "5")
+	c.expect("-ERR invalid expire time in 'set' command", "SET", "3", "x", "EX", "0")
+	c.expect("-ERR value is not an integer or out of range", "SET", "3", "x", "PX", "soon")
+
+	c.expect("+OK", "SET", "4", "v", "PX", "50")
+	c.expect(`"v"`, "GET", "4")
+	time.Sleep(100 * time.Millisecond)
+	c.expect("nil", "GET", "4")
+	c.expect(":-2", "TTL", "4")
+
+	c.expect("-ERR empty values are not supported", "SET", "5", "")
+	c.expect("-ERR wrong number of arguments for 'get' command", "GET")
+	c.expect("-ERR unknown command 'NOPE'", "NOPE")
+}
+
+func TestRESPMultiKey(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	c.expect("+OK", "MSET", "10", "a", "11", "b")
+	c.expect(`["a" "b" nil]`, "MGET", "10", "11", "12")
+	c.expect("-ERR wrong number of arguments for 'mset' command", "MSET", "10", "a", "11")
+	c.expect(":2", "EXISTS", "10", "11", "12")
+	c.expect(":3", "EXISTS", "10", "10", "11")
+	c.expect(":1", "DEL", "10", "12")
+	c.expect(`[nil "b"]`, "MGET", "10", "11")
+}
+
+func TestRESPIncr(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	c.expect(":1", "INCR", "20")
+	c.expect(":2", "INCR", "20")
+	c.expect(`"2"`, "GET", "20")
+
+	c.expect("+OK", "SET", "21", strconv.FormatInt(math.MaxInt64, 10))
+	c.expect("-ERR increment or decrement would overflow", "INCR", "21")
+	c.expect(`"9223372036854775807"`, "GET", "21")
+
+	c.expect("+OK", "SET", "22", "abc")
+	c.expect("-ERR value is not an integer or out of range", "INCR", "22")
+
+	// INCR keeps the key's deadline.
+	c.expect("+OK", "SET", "23", "5", "EX", "100")
+	c.expect(":6", "INCR", "23")
+	c.expect(":100", "TTL", "23")
+}
+
+func TestRESPExpire(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	c.expect(":0", "EXPIRE", "30", "10")
+	c.expect("+OK", "SET", "30", "v")
+	c.expect(":1", "EXPIRE", "30", "100")
+	c.expect(":100", "TTL", "30")
+	c.expect("+OK", "SET", "30", "w")
+	c.expect(":-1", "TTL", "30")
+	c.expect(":1", "EXPIRE", "30", "0")
+	c.expect("nil", "GET", "30")
+	c.expect(":-2", "TTL", "30")
+	c.expect("-ERR value is not an integer or out of range", "EXPIRE", "30", "later")
+}
+
+func TestRESPExpireChecksCanonicalKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.ACL.File = filepath.Join(t.TempDir(), "acl.yaml")
+	policy := "rules:\n  - principal: \"*\"\n    operations: [get, put]\n    keys: [\"7\"]\n"
+	if err := os.WriteFile(cfg.ACL.File, []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := dialRESP(t, newTestServer(t, cfg))
+
+	// "007" and "+7" are key 7, so the ACL must judge them as "7".
+	c.expect("+OK", "SET", "007", "v")
+	c.expect(":1", "EXPIRE", "007", "100")
+	c.expect(":1", "EXPIRE", "+7", "100")
+	if got := c.do("EXPIRE", "70", "100"); !strings.HasPrefix(got, "-NOPERM ") {
+		t.Errorf("EXPIRE on a key the ACL does not allow: got %s, want NOPERM", got)
+	}
+}
+
+// scanAll follows SCAN cursors until the server returns "0".
+func scanAll(t *testing.T, c *respClient, args ...string) []int {
+	t.Helper()
+	var keys []int
+	cursor := "0"
+	for range 100 {
+		reply := c.do(append([]string{"SCAN", cursor}, args...)...)
+		// [cursor [k1 k2 ...]]
+		fields := strings.Fields(strings.NewReplacer("[", " ", "]", " ", `"`, " ").Replace(reply))
+		if len(fields) == 0 {
+			t.Fatalf("SCAN %s: got %s", cursor, reply)
+		}
+		for _, f := range fields[1:] {
+			key, err := strconv.Atoi(f)
+			if err != nil {
+				t.Fatalf("SCAN %s: got %s", cursor, reply)
+			}
+			keys = append(keys, key)
+		}
+		if cursor = fields[0]; cursor == "0" {
+			return keys
+		}
+	}
+	t.Fatal("SCAN never returned cursor 0")
+	return nil
+}
+
+func TestRESPScan(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	want := []int{-1 << 40, -5, -1, 0, 3, 7, 1 << 40}
+	for _, key := range want {
+		c.expect("+OK", "SET", strconv.Itoa(key), "v")
+	}
+
+	if got := scanAll(t, c, "COUNT", "2"); !slices.Equal(got, want) {
+		t.Errorf("SCAN COUNT 2: got %v, want %v", got, want)
+	}
+	if got := scanAll(t, c); !slices.Equal(got, want) {
+		t.Errorf("SCAN: got %v, want %v", got, want)
+	}
+	if got := scanAll(t, c, "MATCH", "-*", "COUNT", "3"); !slices.Equal(got, want[:3]) {
+		t.Errorf("SCAN MATCH -*: got %v, want %v", got, want[:3])
+	}
+
+	// Every key, negative ones included, round-trips through a cursor.
+	// Only the smallest key maps to "0", which starts a scan there, and
+	// it is never a next cursor: some key always comes before it.
+	for _, key := range append(want, math.MinInt64, math.MaxInt64) {
+		got, ok := decodeCursor(encodeCursor(key))
+		if !ok || got != key {
+			t.Errorf("cursor for %d decodes to %d, %v", key, got, ok)
+		}
+		if encodeCursor(key) == "0" && key != math.MinInt64 {
+			t.Errorf("cursor for %d is 0", key)
+		}
+	}
+
+	c.expect("-ERR invalid cursor", "SCAN", "x")
+	c.expect("-ERR syntax error", "SCAN", "0", "COUNT")
+	c.expect("-ERR value is not an integer or out of range", "SCAN", "0", "COUNT", "0")
+}
+
+func TestRESPPing(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	c.expect("+PONG", "PING")
+	c.expect(`"hello"`, "PING", "hello")
+	c.expect("-ERR wrong number of arguments for 'ping' command", "PING", "a", "b")
+}
+
+func TestRESPAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.Enabled = true
+	cfg.Auth.APIKeys = []config.APIKey{
+		{Name: "rw", Key: "secret"},
+		{Name: "ro", Key: "reader", Scopes: []string{"read"}},
+	}
+	s := newTestServer(t, cfg)
+
+	c := dialRESP(t, s)
+	c.expect("-NOAUTH Authentication required.", "GET", "1")
+	c.expect("-NOAUTH Authentication required.", "PING")
+	c.expect("-WRONGPASS invalid username-password pair or user is disabled.", "AUTH", "wrong")
+	c.expect("-NOAUTH Authentication required.", "GET", "1")
+	c.expect("+OK", "AUTH", "default", "secret")
+	c.expect("+OK", "SET", "1", "a")
+	c.expect(`"a"`, "GET", "1")
+
+	ro := dialRESP(t, s)
+	ro.expect("+OK", "AUTH", "reader")
+	ro.expect(`"a"`, "GET", "1")
+	if got := ro.do("SET", "1", "b"); !strings.HasPrefix(got, "-NOPERM ") {
+		t.Errorf("SET with the read scope: got %s, want NOPERM", got)
+	}
+
+	h := dialRESP(t, s)
+	h.expect("-NOAUTH Authentication required.", "HELLO", "3")
+	if got := h.do("HELLO", "3", "AUTH", "default", "secret"); !strings.HasPrefix(got, "%{") {
+		t.Errorf("HELLO 3 AUTH: got %s, want a map", got)
+	}
+	h.expect(`"a"`, "GET", "1")
+}
+
+func TestRESPHello(t *testing.T) {
+	c := dialRESP(t, newTestServer(t, testConfig()))
+
+	// RESP2 until HELLO 3: nulls are $-1.
+	c.expect("nil", "GET", "1")
+	c.expect(`%{"server" "kv" "version" "1.0.0" "proto" :3 "mode" "standalone" "role" "master" "modules" []}`, "HELLO", "3")
+	c.expect("_", "GET", "1")
+	c.expect(`[_ _]`, "MGET", "1", "2")
+	c.expect("+OK", "SET", "1", "a")
+	c.expect("_", "SET", "1", "b", "NX")
+
+	// Back to RESP2, where the map is a flat array.
+	c.expect(`["server" "kv" "version" "1.0.0" "proto" :2 "mode" "standalone" "role" "master" "modules" []]`, "HELLO", "2")
+	c.expect("nil", "GET", "2")
+	c.expect("-NOPROTO unsupported protocol version", "HELLO", "4")
+}
@@ -0,0 +1,239 @@
+// Package server implements the key value HTTP and gRPC APIs, the binary
+// protocol and Redis- and memcached-compatible protocols on top of an LRU
+// cache and a store.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"decsproject/acl"
+	"decsproject/audit"
+	"decsproject/auth"
+	"decsproject/breaker"
+	"decsproject/cache"
+	"decsproject/config"
+	"decsproject/inject"
+	"decsproject/metrics"
+	"decsproject/quota"
+	"decsproject/ratelimit"
+	"decsproject/store"
+	"decsproject/tlsconfig"
+)
+
+type Server struct {
+	cfg     *config.Config
+	store   store.Store
+	cache   *cache.LRUCache
+	inject  *inject.Injector
+	auth    *auth.Authenticator
+	acl     *acl.ACL
+	limiter *ratelimit.Limiter
+	quota   *quota.Tracker
+	audit   *audit.Log
+	// breaker guards the store, or is nil when storage.breaker is off.
+	breaker *breaker.Breaker
+	metrics *metrics.Metrics
+	mux     *http.ServeMux
+
+	// tls is nil when the public listener serves plain HTTP.
+	tls   *tls.Config
+	certs *tlsconfig.CertReloader
+
+	expiry *expiries
+	locks  [lockStripes]sync.Mutex
+	feed   *changeFeed
+
+	// protocols tracks the listeners other than HTTP and the expiry
+	// sweeper started by ListenAndServe, which Serve waits for before
+	// closing the store.
+	protocols sync.WaitGroup
+	// stopProtocols cancels the context they run under, so Serve can stop
+	// them if the HTTP server fails. It is nil unless ListenAndServe
+	// started them.
+	stopProtocols context.CancelFunc
+
+	warming      warming
+	casTokens    casTokens
+	warmedUp     atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+func New(cfg *config.Config, st store.Store) (*Server, error) {
+	a, err := auth.New(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy *acl.ACL
+	if cfg.ACL.File != "" {
+		if policy, err = acl.Load(cfg.ACL.File); err != nil {
+			return nil, err
+		}
+	}
+
+	m := metrics.New()
+	if db := store.DB(st); db != nil {
+		m.RegisterDB(db)
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		store:   store.Observe(st, m.ObserveStore),
+		breaker: store.Breaker(st),
+		cache:   cache.NewLRUCache(cfg.CacheCapacity()),
+		inject:  inject.New(cfg.Inject),
+		auth:    a,
+		acl:     policy,
+		limiter: ratelimit.New(cfg.RateLimit),
+		quota:   quota.New(cfg.Quotas),
+		expiry:  newExpiries(),
+		feed:    newChangeFeed(),
+		metrics: m,
+		mux:     http.NewServeMux(),
+	}
+	m.RegisterCache(s.cache)
+	if s.breaker != nil {
+		m.RegisterBreaker(s.breaker)
+	}
+	if r := store.Retries(st); r != nil {
+		m.RegisterRetries(r)
+	}
+
+	if err := s.loadQuotaUsage(); err != nil {
+		return nil, err
+	}
+	if cfg.Audit.File != "" {
+		if s.audit, err = audit.Open(cfg.Audit.File, int64(cfg.Audit.MaxSize), cfg.Audit.MaxBackups); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.TLS.Enabled() {
+		if s.certs, err = tlsconfig.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			return nil, err
+		}
+		if s.tls, err = tlsconfig.Server(s.certs, cfg.TLS.ClientCAFile, cfg.TLS.ClientAuth); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mux.HandleFunc("/hello", s.hello)
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/readyz", s.readyz)
+	s.mux.Handle("/metrics", m.Handler())
+	s.mux.Handle("/put", s.require(auth.ScopeWrite, s.admit(s.put)))
+	s.mux.Handle("/get", s.require(auth.ScopeRead, s.admit(s.get)))
+	s.mux.Handle("/delete", s.require(auth.ScopeWrite, s.admit(s.del)))
+
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.observe(s.inject.Wrap(s.mux))
+}
+
+// warming records the keys written before WarmUp finishes, so that it
+// does not cache a value it read before one of those writes landed.
+type warming struct {
+	mu      sync.Mutex
+	done    bool
+	written map[int]bool
+}
+
+func (w *warming) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done, w.written = true, nil
+}
+
+// wrote marks key as changed. Writers call it before changing the store.
+func (w *warming) wrote(key int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	if w.written == nil {
+		w.written = map[int]bool{}
+	}
+	w.written[key] = true
+}
+
+// fill calls put unless key has been written, and reports whether it did.
+func (w *warming) fill(key int, put func()) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.written[key] {
+		return false
+	}
+	put()
+	return true
+}
+
+// WarmUp preloads the cache; /readyz reports the server unavailable until
+// it returns, so it may run while the server is already listening. Rows
+// returned by the configured warm-up query are loaded first, then the
+// keys recorded in the snapshot file, coldest first so the hottest keys end
+// up at the front. Keys written since the server was created are skipped.
+func (s *Server) WarmUp(ctx context.Context) {
+	defer s.warmedUp.Store(true)
+	defer s.warming.finish()
+	loaded := 0
+	fill := func(key int, value string) {
+		if s.warming.fill(key, func() { s.cache.Put(strconv.Itoa(key), value) }) {
+			loaded++
+		}
+	}
+
+	if query := s.cfg.Cache.WarmupQuery; query != "" {
+		err := store.Preload(ctx, s.store, query, fill)
+		if err == store.ErrPreloadUnsupported {
+			slog.Warn("Cache warm-up query is not supported by this backend", "backend", s.cfg.Storage.Backend)
+		} else if err != nil {
+			slog.Error("Cache warm-up query failed", "err", err)
+		}
+	}
+
+	if path := s.cfg.Cache.Snapshot; path != "" {
+		keys, err := cache.LoadSnapshot(path)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to read cache snapshot", "path", path, "err", err)
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			key, err := strconv.Atoi(keys[i])
+			if err != nil {
+				continue
+			}
+			value, err := s.store.Get(ctx, key)
+			if err == store.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				slog.Error("Database QueryRow error (warm-up)", "key", key, "err", err)
+				continue
+			}
+			fill(key, value)
+		}
+	}
+
+	slog.Info("Cache warm-up finished", "keys", loaded)
+}
+
+// saveSnapshot records the hottest cache keys for the next WarmUp, if a
+// snapshot file is configured.
+func (s *Server) saveSnapshot() {
+	path := s.cfg.Cache.Snapshot
+	if path == "" {
+		return
+	}
+	if err := s.cache.SaveSnapshot(path, s.cfg.Cache.SnapshotKeys); err != nil {
+		slog.Error("Failed to save cache snapshot", "path", path, "err", err)
+	}
+}
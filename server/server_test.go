@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"decsproject/store"
+)
+
+// heldStore holds every Get after reading the value until release is
+// closed, like a slow database returning a row that is already stale.
+type heldStore struct {
+	*store.Memory
+	read     chan struct{}
+	readOnce sync.Once
+	release  chan struct{}
+}
+
+func (s *heldStore) Get(ctx context.Context, key int) (string, error) {
+	value, err := s.Memory.Get(ctx, key)
+	s.readOnce.Do(func() { close(s.read) })
+	<-s.release
+	return value, err
+}
+
+func TestWarmUpSkipsKeysWrittenMeanwhile(t *testing.T) {
+	st := &heldStore{
+		Memory:  store.NewMemory(),
+		read:    make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	ctx := context.Background()
+	st.Memory.Put(ctx, 1, "old")
+	st.Memory.Put(ctx, 2, "two")
+
+	cfg := testConfig()
+	cfg.Cache.Snapshot = filepath.Join(t.TempDir(), "snapshot")
+	// Hottest first, and warm-up starts with the coldest: key 1.
+	if err := os.WriteFile(cfg.Cache.Snapshot, []byte("2\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warmed := make(chan struct{})
+	go func() {
+		s.WarmUp(ctx)
+		close(warmed)
+	}()
+	select {
+	case <-st.read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("warm-up never read the store")
+	}
+
+	// Key 1 is written while warm-up holds the value it read before.
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("PUT", "/put", strings.NewReader(`{"key":1,"value":"new"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put: got %d %s", rec.Code, rec.Body.String())
+	}
+	close(st.release)
+	select {
+	case <-warmed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("warm-up did not finish")
+	}
+
+	if value, _ := s.cache.Get("1"); value != "new" {
+		t.Errorf("key 1 cached as %q after warm-up, want the value put meanwhile", value)
+	}
+	if value, _ := s.cache.Get("2"); value != "two" {
+		t.Errorf("key 2 cached as %q, want it warmed", value)
+	}
+}
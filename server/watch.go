@@ -0,0 +1,79 @@
+package server
+
+import "sync"
+
+// watchBuffer is how many changes a watcher may fall behind before it is
+// dropped.
+const watchBuffer = 256
+
+// change is a put or delete made through this server.
+type change struct {
+	deleted bool
+	key     int
+	// value is empty for deletes.
+	value   string
+	version uint64
+}
+
+// changeFeed passes changes to watchers, such as gRPC Watch streams. It
+// only sees writes made through this server, not other servers sharing
+// the store.
+type changeFeed struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+// watcher receives changes on C. C is closed if the watcher fell more
+// than watchBuffer changes behind.
+type watcher struct {
+	C <-chan change
+	c chan change
+	// keys limits the watcher to these keys; nil means every key.
+	keys map[int]bool
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{watchers: make(map[*watcher]struct{})}
+}
+
+// watch subscribes to changes to keys, or to every key if keys is empty.
+// The caller must cancel it when done.
+func (f *changeFeed) watch(keys []int) *watcher {
+	c := make(chan change, watchBuffer)
+	w := &watcher{C: c, c: c}
+	if len(keys) > 0 {
+		w.keys = make(map[int]bool, len(keys))
+		for _, key := range keys {
+			w.keys[key] = true
+		}
+	}
+
+	f.mu.Lock()
+	f.watchers[w] = struct{}{}
+	f.mu.Unlock()
+	return w
+}
+
+func (f *changeFeed) cancel(w *watcher) {
+	f.mu.Lock()
+	delete(f.watchers, w)
+	f.mu.Unlock()
+}
+
+// publish passes ch to the watchers interested in its key without
+// blocking; watchers whose buffer is full are dropped.
+func (f *changeFeed) publish(ch change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for w := range f.watchers {
+		if w.keys != nil && !w.keys[ch.key] {
+			continue
+		}
+		select {
+		case w.c <- ch:
+		default:
+			delete(f.watchers, w)
+			close(w.c)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"decsproject/breaker"
+)
+
+// ErrUnavailable is returned without calling the store while its circuit
+// breaker is open.
+var ErrUnavailable = errors.New("store: unavailable, circuit breaker open")
+
+// guarded fails fast once b has seen too many consecutive storage errors.
+// Missing keys, lost compare-and-swap races and calls cancelled by the
+// client are not failures. Ping is passed straight through so health
+// checks always see the real state.
+type guarded struct {
+	Store
+	b *breaker.Breaker
+}
+
+func Guard(st Store, b *breaker.Breaker) Store {
+	return &guarded{Store: st, b: b}
+}
+
+func (g *guarded) Unwrap() Store {
+	return g.Store
+}
+
+func (g *guarded) call(ctx context.Context, fn func() error) (err error) {
+	if ok, _ := g.b.Allow(); !ok {
+		return ErrUnavailable
+	}
+	defer func() {
+		if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+			g.b.Cancel()
+			return
+		}
+		g.b.Record(err == nil || err == ErrNotFound || err == ErrVersionMismatch)
+	}()
+	return fn()
+}
+
+func (g *guarded) Get(ctx context.Context, key int) (value string, err error) {
+	err = g.call(ctx, func() error {
+		value, err = g.Store.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (g *guarded) Put(ctx context.Context, key int, value string) (version uint64, err error) {
+	err = g.call(ctx, func() error {
+		version, err = g.Store.Put(ctx, key, value)
+		return err
+	})
+	return version, err
+}
+
+func (g *guarded) Delete(ctx context.Context, key int) (version uint64, err error) {
+	err = g.call(ctx, func() error {
+		version, err = g.Store.Delete(ctx, key)
+		return err
+	})
+	return version, err
+}
+
+func (g *guarded) GetVersion(ctx context.Context, key int) (value string, version uint64, err error) {
+	err = g.call(ctx, func() error {
+		value, version, err = GetVersion(ctx, g.Store, key)
+		return err
+	})
+	return value, version, err
+}
+
+func (g *guarded) PutIfVersion(ctx context.Context, key int, value string, version uint64) (newVersion uint64, err error) {
+	err = g.call(ctx, func() error {
+		newVersion, err = PutIfVersion(ctx, g.Store, key, value, version)
+		return err
+	})
+	return newVersion, err
+}
+
+// Breaker returns the circuit breaker guarding st, or nil if there is
+// none.
+func Breaker(st Store) *breaker.Breaker {
+	for {
+		switch s := st.(type) {
+		case *guarded:
+			return s.b
+		case interface{ Unwrap() Store }:
+			st = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
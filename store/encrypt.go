@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"decsproject/envelope"
+)
+
+// lockStripes is how many mutexes serialise writes to the same key
+// between requests and re-encryption.
+const lockStripes = 64
+
+// Encrypted seals values with a key ring before they reach the wrapped
+// store and opens them again on the way out.
+type Encrypted struct {
+	Store
+	keys  *envelope.Keyring
+	locks [lockStripes]sync.Mutex
+}
+
+func Encrypt(st Store, keys *envelope.Keyring) *Encrypted {
+	return &Encrypted{Store: st, keys: keys}
+}
+
+func (e *Encrypted) Unwrap() Store {
+	return e.Store
+}
+
+func (e *Encrypted) lock(key int) func() {
+	m := &e.locks[uint(key)%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
+
+func (e *Encrypted) Get(ctx context.Context, key int) (string, error) {
+	value, err := e.Store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return e.keys.Open(key, value)
+}
+
+func (e *Encrypted) Put(ctx context.Context, key int, value string) (uint64, error) {
+	sealed, err := e.keys.Seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+	defer e.lock(key)()
+	return e.Store.Put(ctx, key, sealed)
+}
+
+func (e *Encrypted) GetVersion(ctx context.Context, key int) (string, uint64, error) {
+	value, version, err := GetVersion(ctx, e.Store, key)
+	if err != nil {
+		return "", 0, err
+	}
+	value, err = e.keys.Open(key, value)
+	return value, version, err
+}
+
+// PutIfVersion holds the key's lock so re-encryption, which keeps the
+// version, cannot write back an older value over this one.
+func (e *Encrypted) PutIfVersion(ctx context.Context, key int, value string, version uint64) (uint64, error) {
+	sealed, err := e.keys.Seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+	defer e.lock(key)()
+	return PutIfVersion(ctx, e.Store, key, sealed, version)
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key int) (uint64, error) {
+	defer e.lock(key)()
+	return e.Store.Delete(ctx, key)
+}
+
+// Preload passes fn the decrypted values. Rows that fail to decrypt are
+// skipped and logged.
+func (e *Encrypted) Preload(ctx context.Context, query string, fn func(key int, value string)) error {
+	return Preload(ctx, e.Store, query, func(key int, value string) {
+		plaintext, err := e.keys.Open(key, value)
+		if err != nil {
+			slog.Warn("Failed to decrypt preloaded value", "key", key, "err", err)
+			return
+		}
+		fn(key, plaintext)
+	})
+}
+
+// Reencrypt re-seals every value not sealed with the active key, such as
+// those written before a rotation or before encryption was enabled. It
+// returns how many values it rewrote.
+func (e *Encrypted) Reencrypt(ctx context.Context) (int, error) {
+	active := e.keys.Active()
+
+	var stale []int
+	err := Preload(ctx, e.Store, SelectAll, func(key int, value string) {
+		if envelope.KeyID(value) != active {
+			stale = append(stale, key)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range stale {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		rewrote, err := e.reencrypt(ctx, key, active)
+		if err != nil {
+			return n, err
+		}
+		if rewrote {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// reencrypt rewrites key in place, keeping its version: the plaintext is
+// unchanged, so outstanding versions and CAS tokens stay valid and there
+// is nothing to audit or publish.
+func (e *Encrypted) reencrypt(ctx context.Context, key int, active string) (bool, error) {
+	// Hold the key's lock so a concurrent Put or Delete is not undone by
+	// writing back the value read here.
+	defer e.lock(key)()
+
+	value, version, err := GetVersion(ctx, e.Store, key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if envelope.KeyID(value) == active {
+		return false, nil
+	}
+
+	sealed, err := e.keys.Rewrap(key, value)
+	if err != nil {
+		return false, err
+	}
+	err = Rewrite(ctx, e.Store, key, sealed, version)
+	if err == ErrNotFound || err == ErrVersionMismatch {
+		// Written or deleted by another server; a new value is sealed with
+		// the active key anyway.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Encryption returns the Encrypted wrapper within st, or nil if values are
+// stored in plaintext.
+func Encryption(st Store) *Encrypted {
+	for {
+		switch s := st.(type) {
+		case *Encrypted:
+			return s
+		case interface{ Unwrap() Store }:
+			st = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"decsproject/envelope"
+)
+
+func keyring(t *testing.T, active string) *envelope.Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	var b strings.Builder
+	for i, id := range []string{"old", "new"} {
+		b.WriteString(id + " " + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune('a'+i)), 32))) + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := envelope.LoadKeyring(path, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+// listed adds the Preload that Reencrypt lists values with to Memory.
+type listed struct {
+	*Memory
+}
+
+func (l listed) Preload(ctx context.Context, query string, fn func(key int, value string)) error {
+	keys, _ := l.Keys(ctx, math.MinInt, math.MaxInt)
+	for _, key := range keys {
+		value, _ := l.Get(ctx, key)
+		fn(key, value)
+	}
+	return nil
+}
+
+func TestReencryptKeepsVersions(t *testing.T) {
+	ctx := context.Background()
+	mem := listed{NewMemory()}
+	if _, err := Encrypt(mem, keyring(t, "old")).Put(ctx, 1, "one"); err != nil {
+		t.Fatal(err)
+	}
+	// Written before encryption was enabled.
+	mem.Put(ctx, 2, "two")
+	mem.Put(ctx, 2, "two")
+
+	e := Encrypt(mem, keyring(t, "new"))
+	_, v1, _ := e.GetVersion(ctx, 1)
+	_, v2, _ := e.GetVersion(ctx, 2)
+
+	n, err := e.Reencrypt(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("Reencrypt: got %d, %v; want 2", n, err)
+	}
+	for key, want := range map[int]struct {
+		value   string
+		version uint64
+	}{1: {"one", v1}, 2: {"two", v2}} {
+		raw, version, _ := mem.GetVersion(ctx, key)
+		if envelope.KeyID(raw) != "new" {
+			t.Errorf("key %d sealed under %q, want new", key, envelope.KeyID(raw))
+		}
+		if version != want.version {
+			t.Errorf("key %d at version %d after re-encryption, want %d", key, version, want.version)
+		}
+		if value, _ := e.Get(ctx, key); value != want.value {
+			t.Errorf("key %d: got %q, want %q", key, value, want.value)
+		}
+	}
+	// Versions read before re-encryption, as CAS tokens would be, still
+	// match.
+	if _, err := e.PutIfVersion(ctx, 2, "three", v2); err != nil {
+		t.Errorf("version read before re-encryption rejected: %v", err)
+	}
+
+	if n, err := e.Reencrypt(ctx); err != nil || n != 0 {
+		t.Errorf("second Reencrypt: got %d, %v; want 0", n, err)
+	}
+}
+
+func TestMemoryRewrite(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.Put(ctx, 1, "a")
+
+	for _, tc := range []struct {
+		key     int
+		version uint64
+		want    error
+	}{
+		{1, 2, ErrVersionMismatch},
+		{2, 1, ErrNotFound},
+		{1, 1, nil},
+	} {
+		if err := Rewrite(ctx, m, tc.key, "b", tc.version); err != tc.want {
+			t.Errorf("Rewrite(%d, %d): got %v, want %v", tc.key, tc.version, err, tc.want)
+		}
+	}
+	if value, version, _ := m.GetVersion(ctx, 1); value != "b" || version != 1 {
+		t.Errorf("got %q at version %d, want b at 1", value, version)
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Memory keeps pairs in a map. It is meant for local development and
+// benchmarking the server without a database.
+type Memory struct {
+	mu   sync.RWMutex
+	data map[int]entry
+}
+
+type entry struct {
+	value   string
+	version uint64
+}
+
+func NewMemory() *Memory {
+	return &Memory{data: make(map[int]entry)}
+}
+
+func (m *Memory) Get(ctx context.Context, key int) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, found := m.data[key]
+	if !found {
+		return "", ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (m *Memory) Put(ctx context.Context, key int, value string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := entry{value: value, version: m.data[key].version + 1}
+	m.data[key] = e
+	return e.version, nil
+}
+
+func (m *Memory) GetVersion(ctx context.Context, key int) (string, uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, found := m.data[key]
+	if !found {
+		return "", 0, ErrNotFound
+	}
+	return e.value, e.version, nil
+}
+
+func (m *Memory) PutIfVersion(ctx context.Context, key int, value string, version uint64) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, found := m.data[key]
+	if !found {
+		return 0, ErrNotFound
+	}
+	if e.version != version {
+		return 0, ErrVersionMismatch
+	}
+	e = entry{value: value, version: version + 1}
+	m.data[key] = e
+	return e.version, nil
+}
+
+func (m *Memory) Rewrite(ctx context.Context, key int, value string, version uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, found := m.data[key]
+	if !found {
+		return ErrNotFound
+	}
+	if e.version != version {
+		return ErrVersionMismatch
+	}
+	m.data[key] = entry{value: value, version: version}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key int) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, found := m.data[key]
+	if !found {
+		return 0, ErrNotFound
+	}
+	delete(m.data, key)
+	return e.version, nil
+}
+
+func (m *Memory) Keys(ctx context.Context, from, limit int) ([]int, error) {
+	m.mu.RLock()
+	keys := make([]int, 0, len(m.data))
+	for key := range m.data {
+		if key >= from {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.Sort(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
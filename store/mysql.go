@@ -0,0 +1,294 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"syscall"
+
+	"decsproject/config"
+	"decsproject/tracing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// SelectAll is a Preload query returning every pair.
+const SelectAll = "SELECT id, value FROM KeyValue"
+
+// MySQL stores pairs in the KeyValue (id, value, version) table; see
+// schema.sql.
+type MySQL struct {
+	db *sql.DB
+}
+
+func OpenMySQL(cfg config.StorageConfig) (*MySQL, error) {
+	db, err := sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return &MySQL{db: db}, nil
+}
+
+// DB returns the underlying connection pool.
+func (m *MySQL) DB() *sql.DB {
+	return m.db
+}
+
+// startSpan begins a client span for one SQL statement.
+func startSpan(ctx context.Context, operation, statement string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "mysql "+operation, tracing.KindClient)
+	span.SetAttr("db.system", "mysql")
+	span.SetAttr("db.operation", operation)
+	span.SetAttr("db.statement", statement)
+	return ctx, span
+}
+
+func (m *MySQL) Get(ctx context.Context, key int) (string, error) {
+	sqlQuery := "SELECT value FROM KeyValue WHERE id = ?"
+	ctx, span := startSpan(ctx, "SELECT", sqlQuery)
+	defer span.End()
+
+	var value string
+	err := m.db.QueryRowContext(ctx, sqlQuery, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	span.RecordError(err)
+	return value, err
+}
+
+func (m *MySQL) Put(ctx context.Context, key int, value string) (uint64, error) {
+	// LAST_INSERT_ID(expr) makes the new version come back as the
+	// result's last insert ID, saving a second query.
+	sqlQuery := `
+        INSERT INTO KeyValue (id, value, version)
+        VALUES (?, ?, LAST_INSERT_ID(1))
+        ON DUPLICATE KEY UPDATE value = ?, version = LAST_INSERT_ID(version + 1)`
+
+	ctx, span := startSpan(ctx, "INSERT", sqlQuery)
+	defer span.End()
+
+	result, err := m.db.ExecContext(ctx, sqlQuery, key, value, value)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	version, err := result.LastInsertId()
+	span.RecordError(err)
+	return uint64(version), err
+}
+
+func (m *MySQL) GetVersion(ctx context.Context, key int) (string, uint64, error) {
+	sqlQuery := "SELECT value, version FROM KeyValue WHERE id = ?"
+	ctx, span := startSpan(ctx, "SELECT", sqlQuery)
+	defer span.End()
+
+	var (
+		value   string
+		version uint64
+	)
+	err := m.db.QueryRowContext(ctx, sqlQuery, key).Scan(&value, &version)
+	if err == sql.ErrNoRows {
+		return "", 0, ErrNotFound
+	}
+	span.RecordError(err)
+	return value, version, err
+}
+
+func (m *MySQL) PutIfVersion(ctx context.Context, key int, value string, version uint64) (newVersion uint64, err error) {
+	sqlQuery := "UPDATE KeyValue SET value = ?, version = version + 1 WHERE id = ? AND version = ?"
+	ctx, span := startSpan(ctx, "UPDATE", sqlQuery)
+	defer func() {
+		if err != ErrNotFound && err != ErrVersionMismatch {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	result, err := m.db.ExecContext(ctx, sqlQuery, value, key, version)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		return version + 1, nil
+	}
+
+	// Nothing matched: tell a missing key from a newer version.
+	var exists int
+	err = m.db.QueryRowContext(ctx, "SELECT 1 FROM KeyValue WHERE id = ?", key).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, ErrVersionMismatch
+}
+
+func (m *MySQL) Rewrite(ctx context.Context, key int, value string, version uint64) (err error) {
+	sqlQuery := "UPDATE KeyValue SET value = ? WHERE id = ? AND version = ?"
+	ctx, span := startSpan(ctx, "UPDATE", sqlQuery)
+	defer func() {
+		if err != ErrNotFound && err != ErrVersionMismatch {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	result, err := m.db.ExecContext(ctx, sqlQuery, value, key, version)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return nil
+	}
+
+	// Nothing changed: the row is missing, newer, or already holds value,
+	// as it does when a retried rewrite had taken effect.
+	var current uint64
+	err = m.db.QueryRowContext(ctx, "SELECT version FROM KeyValue WHERE id = ?", key).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if current != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+func (m *MySQL) Delete(ctx context.Context, key int) (version uint64, err error) {
+	sqlQuery := "DELETE FROM KeyValue WHERE id = ?"
+	ctx, span := startSpan(ctx, "DELETE", sqlQuery)
+	defer func() {
+		if err != ErrNotFound {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	// Read the version being deleted under a row lock so a concurrent Put
+	// cannot slip in between.
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, "SELECT version FROM KeyValue WHERE id = ? FOR UPDATE", key).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, sqlQuery, key); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+func (m *MySQL) Preload(ctx context.Context, query string, fn func(key int, value string)) (err error) {
+	ctx, span := startSpan(ctx, "SELECT", query)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key int
+		var value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		fn(key, value)
+	}
+	return rows.Err()
+}
+
+func (m *MySQL) Keys(ctx context.Context, from, limit int) (keys []int, err error) {
+	sqlQuery := "SELECT id FROM KeyValue WHERE id >= ? ORDER BY id LIMIT ?"
+	ctx, span := startSpan(ctx, "SELECT", sqlQuery)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	rows, err := m.db.QueryContext(ctx, sqlQuery, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key int
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (m *MySQL) Ping(ctx context.Context) error {
+	return m.db.PingContext(ctx)
+}
+
+func (m *MySQL) Close() error {
+	return m.db.Close()
+}
+
+// MySQL error numbers worth retrying: the statement or transaction was
+// rolled back and can simply be run again.
+const (
+	errLockWaitTimeout = 1205
+	errDeadlock        = 1213
+)
+
+// ClassifyMySQL is the ClassifyFunc for the MySQL backend. Deadlocks, lock
+// wait timeouts and connections found bad before use are always safe to
+// retry; a connection that broke mid-statement only for reads, as the
+// statement may have been applied.
+func ClassifyMySQL(err error, idempotent bool) string {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case errDeadlock:
+			return "deadlock"
+		case errLockWaitTimeout:
+			return "lock_wait_timeout"
+		}
+		return ""
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return "bad_conn"
+	}
+	if idempotent && (errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)) {
+		return "conn_reset"
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ObserveFunc is called after every store operation with its name
+// ("get", "put", "cas", "delete", "preload", "scan" or "ping"), duration
+// and error.
+type ObserveFunc func(op string, d time.Duration, err error)
+
+// Observe wraps st so that fn sees every operation.
+func Observe(st Store, fn ObserveFunc) Store {
+	return &observed{Store: st, fn: fn}
+}
+
+type observed struct {
+	Store
+	fn ObserveFunc
+}
+
+func (o *observed) Unwrap() Store {
+	return o.Store
+}
+
+func (o *observed) observe(op string, start time.Time, err error) {
+	o.fn(op, time.Since(start), err)
+}
+
+func (o *observed) Get(ctx context.Context, key int) (value string, err error) {
+	defer func(start time.Time) { o.observe("get", start, err) }(time.Now())
+	return o.Store.Get(ctx, key)
+}
+
+func (o *observed) Put(ctx context.Context, key int, value string) (version uint64, err error) {
+	defer func(start time.Time) { o.observe("put", start, err) }(time.Now())
+	return o.Store.Put(ctx, key, value)
+}
+
+func (o *observed) GetVersion(ctx context.Context, key int) (value string, version uint64, err error) {
+	defer func(start time.Time) { o.observe("get", start, err) }(time.Now())
+	return GetVersion(ctx, o.Store, key)
+}
+
+func (o *observed) PutIfVersion(ctx context.Context, key int, value string, version uint64) (newVersion uint64, err error) {
+	defer func(start time.Time) { o.observe("cas", start, err) }(time.Now())
+	return PutIfVersion(ctx, o.Store, key, value, version)
+}
+
+func (o *observed) Delete(ctx context.Context, key int) (version uint64, err error) {
+	defer func(start time.Time) { o.observe("delete", start, err) }(time.Now())
+	return o.Store.Delete(ctx, key)
+}
+
+func (o *observed) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { o.observe("ping", start, err) }(time.Now())
+	return o.Store.Ping(ctx)
+}
+
+func (o *observed) Preload(ctx context.Context, query string, fn func(key int, value string)) (err error) {
+	defer func(start time.Time) { o.observe("preload", start, err) }(time.Now())
+	return Preload(ctx, o.Store, query, fn)
+}
+
+func (o *observed) Keys(ctx context.Context, from, limit int) (keys []int, err error) {
+	defer func(start time.Time) { o.observe("scan", start, err) }(time.Now())
+	return Keys(ctx, o.Store, from, limit)
+}
+
+// ErrPreloadUnsupported is returned by Preload for stores that cannot run a
+// warm-up query.
+var ErrPreloadUnsupported = errors.New("store: warm-up query not supported by this backend")
+
+// Preload runs query on st if it, or a store it wraps, is a Preloader.
+func Preload(ctx context.Context, st Store, query string, fn func(key int, value string)) error {
+	if p, ok := st.(Preloader); ok {
+		return p.Preload(ctx, query, fn)
+	}
+	if u, ok := st.(interface{ Unwrap() Store }); ok {
+		return Preload(ctx, u.Unwrap(), query, fn)
+	}
+	return ErrPreloadUnsupported
+}
+
+// ErrScanUnsupported is returned by Keys for stores that cannot list their
+// keys.
+var ErrScanUnsupported = errors.New("store: listing keys not supported by this backend")
+
+// Keys lists keys of st if it, or a store it wraps, is a Scanner.
+func Keys(ctx context.Context, st Store, from, limit int) ([]int, error) {
+	if sc, ok := st.(Scanner); ok {
+		return sc.Keys(ctx, from, limit)
+	}
+	if u, ok := st.(interface{ Unwrap() Store }); ok {
+		return Keys(ctx, u.Unwrap(), from, limit)
+	}
+	return nil, ErrScanUnsupported
+}
+
+// ErrVersionUnsupported is returned by GetVersion, PutIfVersion and Rewrite for
+// stores that do not expose versions.
+var ErrVersionUnsupported = errors.New("store: versions not supported by this backend")
+
+// GetVersion reads key with its version if st, or a store it wraps, is a
+// Versioner.
+func GetVersion(ctx context.Context, st Store, key int) (string, uint64, error) {
+	if v, ok := st.(Versioner); ok {
+		return v.GetVersion(ctx, key)
+	}
+	if u, ok := st.(interface{ Unwrap() Store }); ok {
+		return GetVersion(ctx, u.Unwrap(), key)
+	}
+	return "", 0, ErrVersionUnsupported
+}
+
+// PutIfVersion replaces key if it is at version, if st, or a store it
+// wraps, is a Versioner.
+func PutIfVersion(ctx context.Context, st Store, key int, value string, version uint64) (uint64, error) {
+	if v, ok := st.(Versioner); ok {
+		return v.PutIfVersion(ctx, key, value, version)
+	}
+	if u, ok := st.(interface{ Unwrap() Store }); ok {
+		return PutIfVersion(ctx, u.Unwrap(), key, value, version)
+	}
+	return 0, ErrVersionUnsupported
+}
+
+// Rewrite replaces key in place if it is at version, if st, or a store it
+// wraps, is a Rewriter.
+func Rewrite(ctx context.Context, st Store, key int, value string, version uint64) error {
+	if r, ok := st.(Rewriter); ok {
+		return r.Rewrite(ctx, key, value, version)
+	}
+	if u, ok := st.(interface{ Unwrap() Store }); ok {
+		return Rewrite(ctx, u.Unwrap(), key, value, version)
+	}
+	return ErrVersionUnsupported
+}
+
+// DB returns the connection pool behind st, unwrapping any wrappers, or
+// nil if st is not backed by database/sql.
+func DB(st Store) *sql.DB {
+	for {
+		switch s := st.(type) {
+		case *MySQL:
+			return s.DB()
+		case interface{ Unwrap() Store }:
+			st = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
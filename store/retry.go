@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ClassifyFunc returns why err is worth retrying, such as "deadlock", or
+// "" if it is not. idempotent reports whether the operation may be
+// repeated even if the failed attempt might have taken effect.
+type ClassifyFunc func(err error, idempotent bool) string
+
+// RetryKey identifies a retry counter.
+type RetryKey struct {
+	Operation string
+	Reason    string
+}
+
+// Retrier retries operations that fail with a transient error, sleeping
+// with full-jitter exponential backoff between attempts. It gives up after
+// maxAttempts or when the next sleep would overrun the context deadline.
+type Retrier struct {
+	Store
+	classify    ClassifyFunc
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+
+	mu      sync.Mutex
+	retries map[RetryKey]uint64
+}
+
+func Retry(st Store, classify ClassifyFunc, maxAttempts int, baseDelay, maxDelay time.Duration) *Retrier {
+	return &Retrier{
+		Store:       st,
+		classify:    classify,
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		retries:     make(map[RetryKey]uint64),
+	}
+}
+
+func (r *Retrier) Unwrap() Store {
+	return r.Store
+}
+
+func (r *Retrier) do(ctx context.Context, op string, idempotent bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.maxAttempts {
+			return err
+		}
+		reason := r.classify(err, idempotent)
+		if reason == "" {
+			return err
+		}
+
+		ceiling := min(r.baseDelay<<(attempt-1), r.maxDelay)
+		delay := rand.N(ceiling + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		r.mu.Lock()
+		r.retries[RetryKey{op, reason}]++
+		r.mu.Unlock()
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+func (r *Retrier) Get(ctx context.Context, key int) (value string, err error) {
+	err = r.do(ctx, "get", true, func() error {
+		value, err = r.Store.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// Put is only retried after errors that mean the write did not happen,
+// since repeating it would bump the version twice.
+func (r *Retrier) Put(ctx context.Context, key int, value string) (version uint64, err error) {
+	err = r.do(ctx, "put", false, func() error {
+		version, err = r.Store.Put(ctx, key, value)
+		return err
+	})
+	return version, err
+}
+
+func (r *Retrier) Delete(ctx context.Context, key int) (version uint64, err error) {
+	err = r.do(ctx, "delete", false, func() error {
+		version, err = r.Store.Delete(ctx, key)
+		return err
+	})
+	return version, err
+}
+
+func (r *Retrier) GetVersion(ctx context.Context, key int) (value string, version uint64, err error) {
+	err = r.do(ctx, "get", true, func() error {
+		value, version, err = GetVersion(ctx, r.Store, key)
+		return err
+	})
+	return value, version, err
+}
+
+func (r *Retrier) PutIfVersion(ctx context.Context, key int, value string, version uint64) (newVersion uint64, err error) {
+	err = r.do(ctx, "cas", false, func() error {
+		newVersion, err = PutIfVersion(ctx, r.Store, key, value, version)
+		return err
+	})
+	return newVersion, err
+}
+
+// Rewrite leaves the version alone, so repeating it is harmless.
+func (r *Retrier) Rewrite(ctx context.Context, key int, value string, version uint64) error {
+	return r.do(ctx, "rewrite", true, func() error {
+		return Rewrite(ctx, r.Store, key, value, version)
+	})
+}
+
+func (r *Retrier) Ping(ctx context.Context) error {
+	return r.do(ctx, "ping", true, func() error {
+		return r.Store.Ping(ctx)
+	})
+}
+
+// Retries returns how many retries have been made, by operation and
+// reason.
+func (r *Retrier) Retries() map[RetryKey]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make(map[RetryKey]uint64, len(r.retries))
+	for k, n := range r.retries {
+		out[k] = n
+	}
+	return out
+}
+
+// Retries returns the Retrier within st, or nil if there is none.
+func Retries(st Store) *Retrier {
+	for {
+		switch s := st.(type) {
+		case *Retrier:
+			return s
+		case interface{ Unwrap() Store }:
+			st = s.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestClassifyMySQL(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		err        error
+		idempotent bool
+		want       string
+	}{
+		{"deadlock", &mysql.MySQLError{Number: 1213}, false, "deadlock"},
+		{"lock wait timeout", &mysql.MySQLError{Number: 1205}, false, "lock_wait_timeout"},
+		{"wrapped deadlock", fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1213}), false, "deadlock"},
+		{"duplicate key", &mysql.MySQLError{Number: 1062}, true, ""},
+		{"bad conn", driver.ErrBadConn, false, "bad_conn"},
+		{"invalid conn, read", mysql.ErrInvalidConn, true, "conn_reset"},
+		{"unexpected EOF, read", io.ErrUnexpectedEOF, true, "conn_reset"},
+		{"connection reset, read", fmt.Errorf("read: %w", syscall.ECONNRESET), true, "conn_reset"},
+		// The write may have been applied before the connection broke.
+		{"invalid conn, write", mysql.ErrInvalidConn, false, ""},
+		{"connection reset, write", syscall.ECONNRESET, false, ""},
+		{"not found", ErrNotFound, true, ""},
+		{"deadline", context.DeadlineExceeded, true, ""},
+	} {
+		if got := ClassifyMySQL(tc.err, tc.idempotent); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// flakyStore fails each call with the next error in errs, then passes
+// calls on to the Memory store.
+type flakyStore struct {
+	*Memory
+	errs  []error
+	calls int
+}
+
+func (f *flakyStore) fail() error {
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *flakyStore) Get(ctx context.Context, key int) (string, error) {
+	if err := f.fail(); err != nil {
+		return "", err
+	}
+	return f.Memory.Get(ctx, key)
+}
+
+func (f *flakyStore) Put(ctx context.Context, key int, value string) (uint64, error) {
+	if err := f.fail(); err != nil {
+		return 0, err
+	}
+	return f.Memory.Put(ctx, key, value)
+}
+
+func (f *flakyStore) Delete(ctx context.Context, key int) (uint64, error) {
+	if err := f.fail(); err != nil {
+		return 0, err
+	}
+	return f.Memory.Delete(ctx, key)
+}
+
+func (f *flakyStore) PutIfVersion(ctx context.Context, key int, value string, version uint64) (uint64, error) {
+	if err := f.fail(); err != nil {
+		return 0, err
+	}
+	return f.Memory.PutIfVersion(ctx, key, value, version)
+}
+
+func TestRetrier(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213}
+	lockWait := &mysql.MySQLError{Number: 1205}
+	reset := fmt.Errorf("read: %w", syscall.ECONNRESET)
+	syntax := errors.New("syntax error")
+
+	ops := map[string]func(ctx context.Context, st Store) error{
+		"get": func(ctx context.Context, st Store) error {
+			_, err := st.Get(ctx, 1)
+			return err
+		},
+		"put": func(ctx context.Context, st Store) error {
+			_, err := st.Put(ctx, 1, "b")
+			return err
+		},
+		"delete": func(ctx context.Context, st Store) error {
+			_, err := st.Delete(ctx, 1)
+			return err
+		},
+		"cas": func(ctx context.Context, st Store) error {
+			_, err := PutIfVersion(ctx, st, 1, "b", 1)
+			return err
+		},
+	}
+
+	for _, tc := range []struct {
+		op        string
+		errs      []error
+		wantCalls int
+		wantErr   error
+		reason    string
+	}{
+		{"get", []error{deadlock, deadlock}, 3, nil, "deadlock"},
+		{"get", []error{reset}, 2, nil, "conn_reset"},
+		{"put", []error{deadlock}, 2, nil, "deadlock"},
+		{"put", []error{lockWait}, 2, nil, "lock_wait_timeout"},
+		{"put", []error{driver.ErrBadConn}, 2, nil, "bad_conn"},
+		// Writes that may have been applied are never repeated.
+		{"put", []error{reset}, 1, reset, ""},
+		{"delete", []error{reset}, 1, reset, ""},
+		{"cas", []error{reset}, 1, reset, ""},
+		{"cas", []error{deadlock}, 2, nil, "deadlock"},
+		{"delete", []error{mysql.ErrInvalidConn}, 1, mysql.ErrInvalidConn, ""},
+		// Errors that are not transient are returned at once.
+		{"get", []error{syntax}, 1, syntax, ""},
+		// Give up after max attempts.
+		{"get", []error{deadlock, deadlock, deadlock, deadlock}, 3, deadlock, "deadlock"},
+	} {
+		mem := NewMemory()
+		mem.Put(context.Background(), 1, "a")
+		st := &flakyStore{Memory: mem, errs: tc.errs}
+		r := Retry(st, ClassifyMySQL, 3, time.Millisecond, time.Millisecond)
+
+		err := ops[tc.op](context.Background(), r)
+		name := fmt.Sprintf("%s after %v", tc.op, tc.errs)
+		if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.wantErr)
+		}
+		if tc.wantErr == nil && err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if st.calls != tc.wantCalls {
+			t.Errorf("%s: %d calls, want %d", name, st.calls, tc.wantCalls)
+		}
+		if tc.reason != "" && r.Retries()[RetryKey{tc.op, tc.reason}] != uint64(tc.wantCalls-1) {
+			t.Errorf("%s: retries %v", name, r.Retries())
+		}
+	}
+}
+
+func TestRetrierStopsAtDeadline(t *testing.T) {
+	st := &flakyStore{Memory: NewMemory(), errs: []error{&mysql.MySQLError{Number: 1213}}}
+	r := Retry(st, ClassifyMySQL, 5, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.Get(ctx, 1); err == nil {
+		t.Fatal("Get succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || st.calls != 1 {
+		t.Errorf("gave up after %v and %d calls", elapsed, st.calls)
+	}
+}
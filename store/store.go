@@ -0,0 +1,102 @@
+// Package store persists key value pairs behind the server's cache.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"decsproject/breaker"
+	"decsproject/config"
+	"decsproject/envelope"
+)
+
+// ErrNotFound is returned when a key is not present in the store.
+var ErrNotFound = errors.New("store: key not found")
+
+// Store persists pairs. Every key carries a version that starts at 1 when
+// the key is created and goes up by one on each write, so a Put returning
+// version 1 created the key and version n replaced version n-1. Versions
+// start again at 1 after a Delete.
+type Store interface {
+	Get(ctx context.Context, key int) (string, error)
+	// Put creates or replaces key and returns its new version.
+	Put(ctx context.Context, key int, value string) (uint64, error)
+	// Delete removes key and returns the version it had, or ErrNotFound if
+	// it was not present.
+	Delete(ctx context.Context, key int) (uint64, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Preloader is implemented by stores that can run a configured query to
+// warm the cache. fn is called for every (key, value) row returned.
+type Preloader interface {
+	Preload(ctx context.Context, query string, fn func(key int, value string)) error
+}
+
+// ErrVersionMismatch is returned by PutIfVersion when the key has been
+// written since the version the caller read.
+var ErrVersionMismatch = errors.New("store: version mismatch")
+
+// Versioner is implemented by stores that can compare and swap on
+// versions. GetVersion returns a key's value with its version;
+// PutIfVersion replaces key only if it is still at version, and returns
+// the new version, ErrVersionMismatch or ErrNotFound.
+type Versioner interface {
+	GetVersion(ctx context.Context, key int) (string, uint64, error)
+	PutIfVersion(ctx context.Context, key int, value string, version uint64) (uint64, error)
+}
+
+// Rewriter is implemented by stores that can replace a value in place.
+// Rewrite stores value under key only if it is still at version, leaving
+// the version as it is, and returns ErrVersionMismatch or ErrNotFound
+// otherwise. It is meant for changing how a value is stored, such as
+// re-encrypting it, without it counting as a write.
+type Rewriter interface {
+	Rewrite(ctx context.Context, key int, value string, version uint64) error
+}
+
+// Scanner is implemented by stores that can list their keys. Keys returns
+// up to limit keys of at least from, in ascending order.
+type Scanner interface {
+	Keys(ctx context.Context, from, limit int) ([]int, error)
+}
+
+// Open returns the backend selected by cfg, retrying transient errors as
+// storage.retry allows, encrypting values if storage.encryption is
+// configured and behind a circuit breaker if storage.breaker is enabled.
+func Open(cfg config.StorageConfig) (Store, error) {
+	var keys *envelope.Keyring
+	if cfg.Encryption.KeyFile != "" {
+		var err error
+		if keys, err = envelope.LoadKeyring(cfg.Encryption.KeyFile, cfg.Encryption.ActiveKey); err != nil {
+			return nil, err
+		}
+	}
+
+	var st Store
+	switch cfg.Backend {
+	case "mysql":
+		m, err := OpenMySQL(cfg)
+		if err != nil {
+			return nil, err
+		}
+		st = m
+		if r := cfg.Retry; r.MaxAttempts > 1 {
+			st = Retry(st, ClassifyMySQL, r.MaxAttempts, r.BaseDelay, r.MaxDelay)
+		}
+	case "memory":
+		st = NewMemory()
+	default:
+		return nil, fmt.Errorf("store: unsupported backend %q", cfg.Backend)
+	}
+
+	if keys != nil {
+		st = Encrypt(st, keys)
+	}
+	if cfg.Breaker.Enabled {
+		st = Guard(st, breaker.New(cfg.Breaker.FailureThreshold, cfg.Breaker.OpenTimeout))
+	}
+	return st, nil
+}
@@ -0,0 +1,268 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates the way cmd/kvcerts does.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := certTemplate("test-ca")
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"), der, key)
+	return &testCA{cert: cert, key: key}
+}
+
+func certTemplate(cn string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+// issueServer writes a server certificate for 127.0.0.1 named cn.
+func (ca *testCA) issueServer(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+	tmpl := certTemplate(cn)
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	ca.issue(t, certFile, keyFile, tmpl)
+}
+
+func (ca *testCA) issueClient(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+	tmpl := certTemplate(cn)
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	ca.issue(t, certFile, keyFile, tmpl)
+}
+
+func (ca *testCA) issue(t *testing.T, certFile, keyFile string, tmpl *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, certFile, keyFile, der, key)
+}
+
+func writePair(t *testing.T, certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve accepts TLS connections on a loopback listener and writes "ok"
+// on each one that completes the handshake.
+func serve(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				io.WriteString(conn, "ok")
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dial connects with cfg, reads the server's greeting and returns the
+// common name of the certificate the server presented.
+func dial(addr string, cfg *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// Under TLS 1.3 a rejected client certificate only surfaces on the
+	// first read.
+	if _, err := io.ReadAll(conn); err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestCertReloaderPicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	ca.issueServer(t, certFile, keyFile, "server-1")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg, err := Server(r, "", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, serverCfg)
+	clientCfg, err := Client(filepath.Join(dir, "ca.pem"), "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cn, err := dial(addr, clientCfg); err != nil || cn != "server-1" {
+		t.Fatalf("before rotation: got %q, %v; want server-1", cn, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	ca.issueServer(t, certFile, keyFile, "server-2")
+	// Make sure the rotation is visible even on filesystems with coarse
+	// modification times.
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cn, err := dial(addr, clientCfg)
+		if err != nil {
+			t.Fatalf("after rotation: %v", err)
+		}
+		if cn == "server-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still served %q after rotation", cn)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertReloaderKeepsCertificateOnBadRotation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	ca.issueServer(t, certFile, keyFile, "server-1")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload accepted a broken key pair")
+	}
+	cert, _ := r.GetCertificate(nil)
+	if cert == nil || cert.Leaf == nil || cert.Leaf.Subject.CommonName != "server-1" {
+		t.Fatal("previous certificate not kept after a failed reload")
+	}
+}
+
+func TestServerRequireClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	ca.issueServer(t, certFile, keyFile, "server")
+	clientCert, clientKey := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	ca.issueClient(t, clientCert, clientKey, "client")
+
+	// A client certificate from a CA the server does not trust.
+	other := t.TempDir()
+	otherCA := newTestCA(t, other)
+	strangerCert, strangerKey := filepath.Join(other, "client.pem"), filepath.Join(other, "client-key.pem")
+	otherCA.issueClient(t, strangerCert, strangerKey, "stranger")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg, err := Server(r, filepath.Join(dir, "ca.pem"), "require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, serverCfg)
+	caFile := filepath.Join(dir, "ca.pem")
+
+	for _, tc := range []struct {
+		name          string
+		cert, key     string
+		wantConnected bool
+	}{
+		{"no certificate", "", "", false},
+		{"untrusted certificate", strangerCert, strangerKey, false},
+		{"trusted certificate", clientCert, clientKey, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := Client(caFile, tc.cert, tc.key, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = dial(addr, cfg)
+			if tc.wantConnected && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tc.wantConnected && err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
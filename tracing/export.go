@@ -0,0 +1,301 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize     = 4096
+	batchSize     = 512
+	flushInterval = time.Second
+)
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer samples new traces at sampleRate and exports finished spans in
+// batches from a background goroutine. Spans that arrive while the queue
+// is full are dropped rather than slowing down requests.
+type Tracer struct {
+	exporter   Exporter
+	sampleRate float64
+
+	queue chan SpanData
+	done  chan struct{}
+	once  sync.Once
+}
+
+func NewTracer(exporter Exporter, sampleRate float64) *Tracer {
+	t := &Tracer{
+		exporter:   exporter,
+		sampleRate: sampleRate,
+		queue:      make(chan SpanData, queueSize),
+		done:       make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(context.Background(), batch); err != nil {
+			slog.Warn("Failed to export spans", "spans", len(batch), "err", err)
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports any queued spans and shuts the exporter down. Spans
+// ended afterwards are dropped, so it should run once the server has
+// drained.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.once.Do(func() {
+		if defaultTracer.Load() == t {
+			SetDefault(nil)
+		}
+		close(t.queue)
+	})
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// WriterExporter writes one JSON object per span to w, suitable for stdout
+// or a file.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+type spanJSON struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	Start      time.Time      `json:"start"`
+	DurationMS float64        `json:"duration_ms"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func (e *WriterExporter) Export(ctx context.Context, spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range spans {
+		out := spanJSON{
+			TraceID:    s.Context.TraceID.String(),
+			SpanID:     s.Context.SpanID.String(),
+			Name:       s.Name,
+			Kind:       kindName(s.Kind),
+			Start:      s.Start,
+			DurationMS: float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+			Attributes: s.Attributes,
+			Error:      s.Error,
+		}
+		if s.Parent.IsValid() {
+			out.ParentID = s.Parent.String()
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown closes the underlying writer if it is a Closer other than
+// stdout or stderr.
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	if c, ok := e.w.(interface {
+		io.Closer
+		Name() string
+	}); ok && c.Name() != "/dev/stdout" && c.Name() != "/dev/stderr" {
+		return c.Close()
+	}
+	return nil
+}
+
+func kindName(k Kind) string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// OTLPExporter posts spans to an OTLP/HTTP collector using the JSON
+// encoding, e.g. to http://localhost:4318/v1/traces.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            map[string]any `json:"status,omitempty"`
+}
+
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	}
+	return map[string]any{"stringValue": fmt.Sprint(v)}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+
+		keys := make([]string, 0, len(s.Attributes))
+		for k := range s.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: k, Value: otlpValue(s.Attributes[k])})
+		}
+
+		if s.Error != "" {
+			span.Status = map[string]any{"code": 2, "message": s.Error}
+		}
+		out = append(out, span)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpKeyValue{{Key: "service.name", Value: otlpValue(e.service)}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "decsproject/tracing"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("tracing: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,243 @@
+// Package tracing records OpenTelemetry-style spans for requests as they
+// pass through the HTTP handlers, cache and store, and exports them in
+// batches.
+//
+// Spans are started with Start, which uses the tracer installed with
+// SetDefault. Until one is installed Start returns a nil *Span, whose
+// methods do nothing, so instrumented code costs almost nothing when
+// tracing is off.
+package tracing
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext identifies a span within a trace, as carried by the W3C
+// traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Kind mirrors the OpenTelemetry span kinds used by the server.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+	Error      string
+}
+
+// Span is an in-progress operation. A nil *Span is valid and records
+// nothing.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SetName replaces the span's name, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Name = name
+}
+
+// SetAttr records a key value attribute on the span.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]any{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with err, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call has
+// any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+// SpanContext returns the identifiers of s, or the zero value for a nil
+// span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// FromContext returns the span started by the innermost Start on ctx.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemote returns ctx carrying a parent span received from a
+// client, so the next Start continues that trace.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault installs t as the tracer used by Start. A nil t turns tracing
+// off.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Start begins a span named name as a child of the span in ctx, or of a
+// remote parent set with ContextWithRemote, and returns a context carrying
+// it.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	if p := FromContext(ctx); p != nil {
+		parent = p.data.Context
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		cryptoRead(sc.TraceID[:])
+		sc.Sampled = rand.Float64() < t.sampleRate
+	}
+	cryptoRead(sc.SpanID[:])
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:    name,
+			Kind:    kind,
+			Context: sc,
+			Parent:  parent.SpanID,
+			Start:   time.Now(),
+		},
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func cryptoRead(b []byte) {
+	cryptorand.Read(b)
+}
+
+// TraceParentHeader is the W3C Trace Context header name.
+const TraceParentHeader = "traceparent"
+
+// ParseTraceParent decodes a version 00 traceparent header value.
+func ParseTraceParent(h string) (SpanContext, error) {
+	var sc SpanContext
+	if len(h) != 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return sc, fmt.Errorf("tracing: malformed traceparent %q", h)
+	}
+	if h[:2] != "00" {
+		return sc, fmt.Errorf("tracing: unsupported traceparent version %q", h[:2])
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(h[3:35])); err != nil {
+		return sc, fmt.Errorf("tracing: malformed trace id: %w", err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(h[36:52])); err != nil {
+		return sc, fmt.Errorf("tracing: malformed parent id: %w", err)
+	}
+	if _, err := hex.Decode(flags[:], []byte(h[53:55])); err != nil {
+		return sc, fmt.Errorf("tracing: malformed trace flags: %w", err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("tracing: all-zero trace or parent id")
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// TraceParent encodes sc as a traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// collector keeps the spans exported to it.
+type collector struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (c *collector) Export(ctx context.Context, spans []SpanData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+func (c *collector) Shutdown(ctx context.Context) error { return nil }
+
+func TestParseTraceParent(t *testing.T) {
+	const h = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Fatalf("got %+v", sc)
+	}
+	if got := sc.TraceParent(); got != h {
+		t.Errorf("TraceParent() = %q, want %q", got, h)
+	}
+
+	for _, bad := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(bad); err == nil {
+			t.Errorf("ParseTraceParent(%q) accepted", bad)
+		}
+	}
+}
+
+func TestStartPropagatesRemoteParent(t *testing.T) {
+	exp := &collector{}
+	// Never sample new traces, so only the remote flag can turn it on.
+	tracer := NewTracer(exp, 0)
+	SetDefault(tracer)
+	t.Cleanup(func() { SetDefault(nil) })
+
+	remote, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, server := Start(ContextWithRemote(context.Background(), remote), "GET /get", KindServer)
+	_, child := Start(ctx, "store.get", KindClient)
+
+	for _, s := range []*Span{server, child} {
+		sc := s.SpanContext()
+		if sc.TraceID != remote.TraceID || !sc.Sampled {
+			t.Errorf("%s: got %+v, want trace %s sampled", s.data.Name, sc, remote.TraceID)
+		}
+	}
+	if server.data.Parent != remote.SpanID {
+		t.Errorf("server span parent %s, want the remote %s", server.data.Parent, remote.SpanID)
+	}
+	if child.data.Parent != server.SpanContext().SpanID {
+		t.Errorf("child span parent %s, want the server span %s", child.data.Parent, server.SpanContext().SpanID)
+	}
+	if child.SpanContext().SpanID == server.SpanContext().SpanID {
+		t.Error("child reused its parent's span ID")
+	}
+
+	// The child's traceparent names it as the parent of the next hop.
+	next, err := ParseTraceParent(child.SpanContext().TraceParent())
+	if err != nil || next != child.SpanContext() {
+		t.Errorf("traceparent round-trip: got %+v, %v", next, err)
+	}
+
+	// An unsampled remote parent keeps the trace unsampled.
+	remote.Sampled = false
+	_, unsampled := Start(ContextWithRemote(context.Background(), remote), "GET /get", KindServer)
+	unsampled.End()
+
+	child.End()
+	server.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.spans) != 2 {
+		t.Fatalf("exported %d spans, want the 2 sampled ones", len(exp.spans))
+	}
+	for _, s := range exp.spans {
+		if s.Context.TraceID != remote.TraceID {
+			t.Errorf("exported span %s in trace %s", s.Name, s.Context.TraceID)
+		}
+	}
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "op", KindInternal)
+	if span != nil || FromContext(ctx) != nil {
+		t.Fatal("Start without a tracer returned a span")
+	}
+	// A nil span is safe to use.
+	span.SetAttr("k", "v")
+	span.RecordError(context.Canceled)
+	span.End()
+}